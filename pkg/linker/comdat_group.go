@@ -0,0 +1,77 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+const GRP_COMDAT uint32 = 1
+
+// a comdat group is a set of sections (e.g. an inline function and its data)
+// that every object file instantiating it emits, only one copy is kept
+// the signature is the name of the symbol the group header points to
+type ComdatGroup struct {
+	File      *ObjectFile
+	Signature string
+	Members   []uint32 // section indexes
+}
+
+// SHT_GROUP content is a flag word followed by member section indexes
+func (f *ObjectFile) ParseComdatGroups() {
+	f.DiscardedSecs = make([]bool, f.TotalSecs)
+	for _, shdr := range f.ElfSecHdrs {
+		if shdr.Type != uint32(elf.SHT_GROUP) {
+			continue
+		}
+		words := utils.ReadSlice[uint32](f.GetBytesFromShdr(&shdr), 4)
+		if len(words) == 0 || words[0]&GRP_COMDAT == 0 {
+			continue
+		}
+		if shdr.Info >= uint32(len(f.ElfSyms)) {
+			utils.Fatal(f.GetFileName() + ": invalid group signature symbol")
+		}
+		f.ComdatGroups = append(f.ComdatGroups, &ComdatGroup{
+			File:      f,
+			Signature: ElfGetName(f.SymStrTab, f.ElfSyms[shdr.Info].Name),
+			Members:   words[1:],
+		})
+	}
+}
+
+func (f *ObjectFile) IsSectionDiscarded(shndx uint32) bool {
+	return shndx < uint32(len(f.DiscardedSecs)) && f.DiscardedSecs[shndx]
+}
+
+// symbols defined in discarded sections are not definitions anymore
+func (f *ObjectFile) IsSymbolDiscarded(idx uint32) bool {
+	esym := &f.ElfSyms[idx]
+	if esym.IsAbs() || esym.IsUndef() || esym.IsCommon() {
+		return false
+	}
+	return f.IsSectionDiscarded(esym.GetShndx(f.SymtabShndxSec, idx))
+}
+
+// find the section in the kept copy of the group with the same name
+func (f *ObjectFile) GetComdatReplacement(ctx *Context, shndx uint32) *InputSection {
+	name := ElfGetName(f.ShStrTab, f.ElfSecHdrs[shndx].Name)
+	for _, group := range f.ComdatGroups {
+		kept := ctx.ComdatGroups[group.Signature]
+		if kept == nil || kept == group {
+			continue
+		}
+		isMember := false
+		for _, m := range group.Members {
+			isMember = isMember || m == shndx
+		}
+		if !isMember {
+			continue
+		}
+		for _, m := range kept.Members {
+			isec := kept.File.InputSections[m]
+			if isec != nil && isec.Name == name {
+				return isec
+			}
+		}
+	}
+	return nil
+}
@@ -5,14 +5,44 @@ import (
 	"fmt"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
 	"os"
+	"strconv"
 	"strings"
 )
 
 type Args struct {
 	Output       string
+	Entry        string
 	Machine      MachineType
 	LibraryPaths []string
 	ObjFiles     []*ObjectFile
+
+	AllowMultipleDefinition bool
+	SortCommon              string
+	WarnCommon              bool
+	UnresolvedSymbols       string
+	WarnUnresolvedSymbols   bool
+	ErrorLimit              int
+	EhFrameHdr              bool
+	Undefined               []string
+	GcSections              bool
+	PrintGcSections         bool
+	Icf                     string
+	PrintIcfSections        bool
+	Map                     string // -Map file, "-" is stdout (-M)
+	Relax                   bool
+	Static                  bool // -static, libraries are only searched as archives
+	DynamicLinker           string
+	NoDynamicLinker         bool // no .interp, the output relocates itself (static-pie)
+	Rpaths                  []string
+	EnableNewDtags          bool // DT_RUNPATH instead of DT_RPATH
+	ExportDynamic           bool
+	HashStyle               string
+	Shared                  bool // -shared, a shared object instead of an executable
+	Soname                  string
+	Pie                     bool // -pie, a position independent executable
+	PackRelativeRelocs      bool // -z pack-relative-relocs, .relr.dyn
+	Relro                   bool // -z relro, PT_GNU_RELRO
+	ZNow                    bool // -z now, no lazy binding
 }
 
 type Context struct {
@@ -25,17 +55,52 @@ type Context struct {
 	OutputShdrsWriter      *OutputShdrsWriter
 	OutputPhdrsWriter      *OutputPhdrsWriter
 	OutputGotSectionWriter *OutputGotSectionWriter
+	OutputShStrtabWriter   *OutputShStrtabWriter
+	OutputSymtabWriter     *OutputSymtabWriter
+	OutputStrtabWriter     *OutputStrtabWriter
+	OutputEhFrameWriter    *OutputEhFrameWriter
+	OutputEhFrameHdrWriter *OutputEhFrameHdrWriter
+	OutputIpltWriter       *OutputIpltWriter
+	OutputRelaIpltWriter   *OutputRelaIpltWriter
+	OutputInterpWriter     *OutputInterpWriter
+	OutputDynamicWriter    *OutputDynamicWriter
+	OutputDynsymWriter     *OutputDynsymWriter
+	OutputDynstrWriter     *OutputStrtabWriter
+	OutputHashWriter       *OutputHashWriter
+	OutputGnuHashWriter    *OutputGnuHashWriter
+	OutputRelaDynWriter    *OutputRelaDynWriter
+	OutputRelrDynWriter    *OutputRelrDynWriter
+	OutputVersymWriter     *OutputVersymWriter
+	OutputVerdefWriter     *OutputVerdefWriter
+	OutputVerneedWriter    *OutputVerneedWriter
+	OutputRelaPltWriter    *OutputRelaPltWriter
+	OutputPltWriter        *OutputPltWriter
+	OutputGotPltWriter     *OutputGotPltWriter
+	CopyrelSection         *InputSection // data of shared objects copied by R_RISCV_COPY
 	OutputSections         []*OutputSection
 	TLSSegmentAddr         uint64
+	InternalObj            *ObjectFile
+	ComdatGroups           map[string]*ComdatGroup
+	VersionNames           []string // version nodes of --version-script
+	VersionPatterns        []VersionPattern
 }
 
 func NewContext() *Context {
 	return &Context{
 		Args: Args{
 			Output:  "a.out",
+			Entry:   "_start",
 			Machine: MachineTypeNone,
+
+			UnresolvedSymbols: "report-all",
+			Icf:               "none",
+			ErrorLimit:        20,
+			Relax:             true,
+			HashStyle:         "both",
+			Relro:             true,
 		},
-		SymbolMap: make(map[string]*Symbol),
+		SymbolMap:    make(map[string]*Symbol),
+		ComdatGroups: make(map[string]*ComdatGroup),
 	}
 }
 
@@ -89,6 +154,7 @@ func (c *Context) ParseArgs(ctx *Context, version string) []string {
 	}
 
 	remaining := make([]string, 0)
+	unresolvedSet := false
 	for len(args) > 0 {
 		if readFlag("help") {
 			fmt.Printf("usage: %s [options] files...\n", os.Args[0])
@@ -97,25 +163,163 @@ func (c *Context) ParseArgs(ctx *Context, version string) []string {
 			ctx.Args.Output = arg
 		} else if readFlag("v") || readFlag("version") {
 			fmt.Printf("simple-linker %s\n", version)
+		} else if readOpt("Map") {
+			ctx.Args.Map = arg
+		} else if readFlag("M") || readFlag("print-map") {
+			ctx.Args.Map = "-"
 		} else if readOpt("m") {
 			if arg == "elf64lriscv" {
 				ctx.Args.Machine = MachineTypeRISCV64
 			} else {
 				utils.Fatal("Unknown -m argument")
 			}
+		} else if readOpt("e") || readOpt("entry") {
+			ctx.Args.Entry = arg
+		} else if readFlag("allow-multiple-definition") {
+			ctx.Args.AllowMultipleDefinition = true
+		} else if readFlag("sort-common") {
+			ctx.Args.SortCommon = "descending"
+		} else if readOpt("sort-common") {
+			if arg != "ascending" && arg != "descending" {
+				utils.Fatal("Unknown --sort-common argument")
+			}
+			ctx.Args.SortCommon = arg
+		} else if readFlag("warn-common") {
+			ctx.Args.WarnCommon = true
+		} else if readOpt("unresolved-symbols") {
+			switch arg {
+			case "ignore-all", "report-all", "ignore-in-object-files",
+				"ignore-in-shared-libs":
+				ctx.Args.UnresolvedSymbols = arg
+				unresolvedSet = true
+			default:
+				utils.Fatal("Unknown --unresolved-symbols argument")
+			}
+		} else if readFlag("no-undefined") {
+			ctx.Args.UnresolvedSymbols = "report-all"
+			unresolvedSet = true
+		} else if readFlag("warn-unresolved-symbols") {
+			ctx.Args.WarnUnresolvedSymbols = true
+		} else if readFlag("error-unresolved-symbols") {
+			ctx.Args.WarnUnresolvedSymbols = false
+		} else if readOpt("error-limit") {
+			limit, err := strconv.Atoi(arg)
+			if err != nil || limit < 0 {
+				utils.Fatal("Invalid --error-limit argument")
+			}
+			ctx.Args.ErrorLimit = limit
+		} else if readFlag("eh-frame-hdr") {
+			ctx.Args.EhFrameHdr = true
+		} else if readFlag("no-eh-frame-hdr") {
+			ctx.Args.EhFrameHdr = false
+		} else if readOpt("u") || readOpt("undefined") {
+			ctx.Args.Undefined = append(ctx.Args.Undefined, arg)
+		} else if readFlag("gc-sections") {
+			ctx.Args.GcSections = true
+		} else if readFlag("no-gc-sections") {
+			ctx.Args.GcSections = false
+		} else if readFlag("print-gc-sections") {
+			ctx.Args.PrintGcSections = true
+		} else if readFlag("no-print-gc-sections") {
+			ctx.Args.PrintGcSections = false
+		} else if readOpt("icf") {
+			if arg != "all" && arg != "safe" && arg != "none" {
+				utils.Fatal("Unknown --icf argument")
+			}
+			ctx.Args.Icf = arg
+		} else if readFlag("print-icf-sections") {
+			ctx.Args.PrintIcfSections = true
+		} else if readFlag("no-print-icf-sections") {
+			ctx.Args.PrintIcfSections = false
+		} else if readFlag("relax") {
+			ctx.Args.Relax = true
+		} else if readFlag("no-relax") {
+			ctx.Args.Relax = false
+		} else if readOpt("z") {
+			switch arg {
+			case "muldefs":
+				ctx.Args.AllowMultipleDefinition = true
+			case "defs":
+				ctx.Args.UnresolvedSymbols = "report-all"
+				unresolvedSet = true
+			case "undefs":
+				ctx.Args.UnresolvedSymbols = "ignore-all"
+				unresolvedSet = true
+			case "pack-relative-relocs":
+				ctx.Args.PackRelativeRelocs = true
+			case "nopack-relative-relocs":
+				ctx.Args.PackRelativeRelocs = false
+			case "relro":
+				ctx.Args.Relro = true
+			case "norelro":
+				ctx.Args.Relro = false
+			case "now":
+				ctx.Args.ZNow = true
+			case "lazy":
+				ctx.Args.ZNow = false
+			default:
+				// Ignored
+			}
+		} else if readFlag("shared") || readFlag("Bshareable") {
+			ctx.Args.Shared = true
+		} else if readOpt("soname") || readOpt("h") {
+			ctx.Args.Soname = arg
+		} else if readFlag("pie") || readFlag("pic-executable") {
+			ctx.Args.Pie = true
+		} else if readFlag("no-pie") || readFlag("no-pic-executable") {
+			ctx.Args.Pie = false
+		} else if readFlag("static-pie") {
+			// no dynamic loader, the libc start code relocates the executable
+			ctx.Args.Static = true
+			ctx.Args.Pie = true
+		} else if readFlag("static") {
+			ctx.Args.Static = true
+		} else if readFlag("Bstatic") || readFlag("dn") || readFlag("non_shared") {
+			// position dependent, applies to the libraries after it
+			remaining = append(remaining, "-Bstatic")
+		} else if readFlag("Bdynamic") || readFlag("dy") || readFlag("call_shared") {
+			remaining = append(remaining, "-Bdynamic")
+		} else if readFlag("as-needed") {
+			remaining = append(remaining, "--as-needed")
+		} else if readFlag("no-as-needed") {
+			remaining = append(remaining, "--no-as-needed")
+		} else if readOpt("dynamic-linker") || readOpt("I") {
+			ctx.Args.DynamicLinker = arg
+			ctx.Args.NoDynamicLinker = false
+		} else if readFlag("no-dynamic-linker") {
+			// gcc -static-pie passes -static -pie --no-dynamic-linker
+			ctx.Args.NoDynamicLinker = true
+		} else if readOpt("rpath") {
+			ctx.Args.Rpaths = append(ctx.Args.Rpaths, arg)
+		} else if readFlag("enable-new-dtags") {
+			ctx.Args.EnableNewDtags = true
+		} else if readFlag("disable-new-dtags") {
+			ctx.Args.EnableNewDtags = false
+		} else if readFlag("export-dynamic") || readFlag("E") {
+			ctx.Args.ExportDynamic = true
+		} else if readFlag("no-export-dynamic") {
+			ctx.Args.ExportDynamic = false
+		} else if readOpt("version-script") {
+			ctx.ReadVersionScript(NewFile(arg))
+		} else if readOpt("pack-dyn-relocs") {
+			if arg != "relr" && arg != "none" {
+				utils.Fatal("Unknown --pack-dyn-relocs argument")
+			}
+			ctx.Args.PackRelativeRelocs = arg == "relr"
+		} else if readOpt("hash-style") {
+			if arg != "sysv" && arg != "gnu" && arg != "both" {
+				utils.Fatal("Unknown --hash-style argument")
+			}
+			ctx.Args.HashStyle = arg
 		} else if readOpt("L") {
 			ctx.Args.LibraryPaths = append(ctx.Args.LibraryPaths, arg)
 		} else if readOpt("sysroot") ||
 			readOpt("plugin") ||
 			readOpt("plugin-opt") ||
-			readOpt("hash-style") ||
 			readOpt("build-id") ||
-			readFlag("static") ||
-			readFlag("as-needed") ||
 			readFlag("start-group") ||
 			readFlag("end-group") ||
-			readFlag("s") ||
-			readFlag("no-relax") {
+			readFlag("s") {
 			// Ignored
 		} else {
 			remaining = append(remaining, args[0])
@@ -124,42 +328,113 @@ func (c *Context) ParseArgs(ctx *Context, version string) []string {
 
 	}
 
+	// undefined symbols of a shared object may be defined by the modules
+	// it's loaded with, like gnu ld they are only reported with -z defs
+	if ctx.Args.Shared && !unresolvedSet {
+		ctx.Args.UnresolvedSymbols = "ignore-all"
+	}
+	if ctx.Args.Shared {
+		ctx.Args.Pie = false
+	}
 	return remaining
 }
 
+// the output can be loaded at any address
+func (c *Context) IsPic() bool {
+	return c.Args.Shared || c.Args.Pie
+}
+
+// a position independent executable without a dynamic loader
+func (c *Context) IsStaticPie() bool {
+	return c.Args.Static && c.Args.Pie
+}
+
+// shared objects are linked, or the output is position independent,
+// the output needs the dynamic loader (or relocates itself with static-pie)
+func (c *Context) IsDynamic() bool {
+	if c.IsPic() {
+		return true
+	}
+	for _, file := range c.Args.ObjFiles {
+		if file.IsDso {
+			return true
+		}
+	}
+	return false
+}
+
 // note that obj files in archive files are not alive
 // and those are in archive files are alive
+// --as-needed and -Bstatic apply to the files after them
 func (c *Context) FillInObjFiles(remaining []string) {
+	asNeeded := false
+	static := c.Args.Static
 	for _, name := range remaining {
-		// lib file
-		if strings.HasPrefix(name, "-l") {
-			libName := "lib" + name[2:] + ".a"
-			files := c.readArchiveMembers(libName)
-			for _, file := range files {
-				utils.Assert(GetFileTypeFromContent(file.Content) == FileTypeObject)
-				CheckFileCompatibility(c, file)
-				NewObjectFile(file, false, c)
+		switch name {
+		case "--as-needed":
+			asNeeded = true
+		case "--no-as-needed":
+			asNeeded = false
+		case "-Bstatic":
+			static = true
+		case "-Bdynamic":
+			static = false
+		default:
+			// lib file
+			if strings.HasPrefix(name, "-l") {
+				c.ReadFile(c.FindLibrary(name[2:], static), false, asNeeded)
+				continue
 			}
-			continue
+			c.ReadFile(NewFile(name), true, asNeeded)
 		}
+	}
+}
 
-		file := NewFile(name)
+// objects given directly are alive, archive members are not until needed
+func (c *Context) ReadFile(file *File, isAlive bool, asNeeded bool) {
+	switch GetFileTypeFromContent(file.Content) {
+	case FileTypeObject:
+		CheckFileCompatibility(c, file)
+		NewObjectFile(file, isAlive, c)
+	case FileTypeArchive:
+		for _, member := range readArchiveMembers(file) {
+			utils.Assert(GetFileTypeFromContent(member.Content) == FileTypeObject)
+			CheckFileCompatibility(c, member)
+			NewObjectFile(member, false, c)
+		}
+	case FileTypeSharedObject:
 		CheckFileCompatibility(c, file)
-		NewObjectFile(file, true, c)
+		NewSharedFile(file, asNeeded, c)
+	case FileTypeText:
+		c.ReadLinkerScript(file, asNeeded)
+	default:
+		utils.Fatal(file.Name + ": unknown file type")
 	}
 }
 
 // -L specifies the library path, and -l specifies the filename
-func (c *Context) readArchiveMembers(filename string) []*File {
-	var file *File
+// each path is tried for libfoo.so then libfoo.a, -l:foo.a names the file
+func (c *Context) FindLibrary(name string, static bool) *File {
+	filenames := []string{"lib" + name + ".so", "lib" + name + ".a"}
+	if static {
+		filenames = filenames[1:]
+	}
+	if strings.HasPrefix(name, ":") {
+		filenames = []string{name[1:]}
+	}
+
 	for _, path := range c.Args.LibraryPaths {
-		file = NewFileNoFatal(path + "/" + filename)
-		if file != nil {
-			break
+		for _, filename := range filenames {
+			if file := NewFileNoFatal(path + "/" + filename); file != nil {
+				return file
+			}
 		}
 	}
-	utils.Assert(file != nil)
+	utils.Fatal("library not found: -l" + name)
+	return nil
+}
 
+func readArchiveMembers(file *File) []*File {
 	ret := make([]*File, 0)
 	// first hdr, section, second hdr section....
 	// [!<arch>\n][ArHdr][]\n[ArHdr][][ArHdr][][ArHdr][]\n
@@ -201,7 +476,7 @@ func (c *Context) GetSymbol(name string) *Symbol {
 	if sym, ok := c.SymbolMap[name]; ok {
 		return sym
 	}
-	c.SymbolMap[name] = NewSymbol(nil, "") // for file with definition ot overwrite
+	c.SymbolMap[name] = NewSymbol(nil, name) // for file with definition ot overwrite
 	return c.SymbolMap[name]
 }
 
@@ -232,15 +507,54 @@ func (c *Context) GetMergedSection(iSec *InputSection) *MergedSection {
 	return newMSec
 }
 
-// not initialized
-//func (ctx *Context) CreateInternalFile() {
-//	obj := &ObjectFile{}
-//	ctx.InternalObj = obj
-//	ctx.Args.ObjFiles = append(ctx.Args.ObjFiles, obj)
-//	// first symbol is empty
-//	ctx.InternalEsyms = make([]Sym, 1)
-//	obj.Symbols = append(ctx.InternalObj.Symbols, NewSymbol(obj, ""))
-//	obj.IsAlive = true
-//	obj.FirstGlobal = 1
-//	obj.ElfSyms = ctx.InternalEsyms
-//}
+// the internal file holds the symbols and sections defined by the linker itself,
+// such as _end and the section for common symbols
+// it has no section headers, so parsing it does nothing
+func (c *Context) CreateInternalFile() {
+	obj := &ObjectFile{
+		File:        &File{Name: "<internal>"},
+		IsAlive:     true,
+		FirstGlobal: thunkSymIdx + 1,
+	}
+	// first symbol is empty, the next one is shared by all thunk entries,
+	// their section is taken from the symbol like for the other internal ones
+	obj.ElfSyms = make([]Sym, thunkSymIdx+1)
+	obj.ElfSyms[thunkSymIdx] = Sym{
+		Info:  uint8(elf.STB_LOCAL)<<4 | uint8(elf.STT_FUNC),
+		Shndx: uint16(elf.SHN_ABS),
+		Size:  ThunkEntrySize,
+	}
+	obj.Symbols = append(obj.Symbols, NewSymbol(obj, ""), NewSymbol(obj, ""))
+	obj.TotalSyms = thunkSymIdx + 1
+	// section 0 is null like in object files, so symbols in
+	// internal sections (e.g. thunks) never look undefined
+	obj.InputSections = []*InputSection{nil}
+	c.InternalObj = obj
+	c.Args.ObjFiles = append(c.Args.ObjFiles, obj)
+}
+
+// symbols defined by a live object file are not overwritten
+// the value is filled in after addresses are set (FixInternalSymbols)
+func (c *Context) AddInternalSymbol(name string) *Symbol {
+	sym := c.GetSymbol(name)
+	if sym.File != nil && sym.File.IsAlive && !sym.File.IsDso {
+		return nil
+	}
+
+	obj := c.InternalObj
+	// hidden, they are never exported
+	esym := Sym{
+		Info:  uint8(elf.STB_GLOBAL)<<4 | uint8(elf.STT_NOTYPE),
+		Other: uint8(elf.STV_HIDDEN),
+		Shndx: uint16(elf.SHN_ABS),
+	}
+	obj.ElfSyms = append(obj.ElfSyms, esym)
+	sym.MergeVisibility(esym.Visibility())
+	sym.File = obj
+	sym.SetInputSection(nil)
+	sym.SetValue(0)
+	sym.SetSymIdx(obj.TotalSyms)
+	obj.Symbols = append(obj.Symbols, sym)
+	obj.TotalSyms++
+	return sym
+}
@@ -0,0 +1,134 @@
+package linker
+
+import (
+	"bytes"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"sort"
+)
+
+// .eh_frame is a list of records, each starts with a 4-byte length
+// CIE (common information entry) has id 0, and is shared by many FDEs
+// FDE (frame description entry) describes one function,
+// its id is the distance back to its CIE
+type CieRecord struct {
+	ObjFile      *ObjectFile
+	Offset       uint64 // offset inside the input .eh_frame
+	Contents     []byte
+	Rels         []Rela
+	OutputOffset uint64
+	Leader       *CieRecord // identical CIEs share one leader, only leaders are written
+}
+
+type FdeRecord struct {
+	ObjFile      *ObjectFile
+	Offset       uint64
+	Contents     []byte
+	Rels         []Rela
+	Cie          *CieRecord
+	OutputOffset uint64
+	IsAlive      bool
+}
+
+func (c *CieRecord) IsLeader() bool {
+	return c.Leader == c
+}
+
+// relocations are compared by target symbol and addend, not the symbol index
+func (c *CieRecord) Equals(other *CieRecord) bool {
+	if !bytes.Equal(c.Contents, other.Contents) || len(c.Rels) != len(other.Rels) {
+		return false
+	}
+	for i := range c.Rels {
+		x := c.Rels[i]
+		y := other.Rels[i]
+		if x.Offset-c.Offset != y.Offset-other.Offset || x.Type != y.Type ||
+			x.Addend != y.Addend ||
+			c.ObjFile.Symbols[x.Sym] != other.ObjFile.Symbols[y.Sym] {
+			return false
+		}
+	}
+	return true
+}
+
+// the first relocation of an FDE is its pc_begin, pointing to the function
+func (f *FdeRecord) GetTargetSection() *InputSection {
+	if len(f.Rels) == 0 || f.Rels[0].Offset != f.Offset+8 {
+		return nil
+	}
+	return f.ObjFile.Symbols[f.Rels[0].Sym].InputSection
+}
+
+// FDEs of discarded functions are dropped
+func (f *FdeRecord) UpdateIsAlive() {
+	target := f.GetTargetSection()
+	f.IsAlive = target != nil && target.IsAlive
+}
+
+// address of the function this FDE describes
+func (f *FdeRecord) GetInitialLocation() uint64 {
+	rel := f.Rels[0]
+	return f.ObjFile.Symbols[rel.Sym].GetAddr() + f.ObjFile.GetRelAddend(&rel)
+}
+
+// split every .eh_frame into CIEs and FDEs, relocations are assigned by offset
+// the input section is no longer used, records are written by OutputEhFrameWriter
+func (f *ObjectFile) ParseEhFrame() {
+	for _, isec := range f.InputSections {
+		if isec == nil || !isec.IsAlive || isec.Name != ".eh_frame" {
+			continue
+		}
+		isec.IsAlive = false
+		f.EhFrameSection = isec
+
+		rels := append([]Rela{}, isec.GetRels()...)
+		sort.SliceStable(rels, func(i, j int) bool {
+			return rels[i].Offset < rels[j].Offset
+		})
+
+		data := isec.Content
+		cies := make(map[uint64]*CieRecord)
+		relIdx := 0
+		offset := uint64(0)
+		for offset < uint64(len(data)) {
+			size := uint64(utils.ReadWithReturn[uint32](data[offset:]))
+			// terminator
+			if size == 0 {
+				break
+			}
+			if size == 0xffffffff {
+				utils.Fatal(f.GetFileName() + ": 64-bit .eh_frame is not supported")
+			}
+
+			end := offset + 4 + size
+			begin := relIdx
+			for relIdx < len(rels) && rels[relIdx].Offset < end {
+				relIdx++
+			}
+
+			id := uint64(utils.ReadWithReturn[uint32](data[offset+4:]))
+			if id == 0 {
+				cie := &CieRecord{
+					ObjFile:  f,
+					Offset:   offset,
+					Contents: data[offset:end],
+					Rels:     rels[begin:relIdx],
+				}
+				cies[offset] = cie
+				f.Cies = append(f.Cies, cie)
+			} else {
+				cie, ok := cies[offset+4-id]
+				if !ok {
+					utils.Fatal(f.GetFileName() + ": bad CIE pointer in .eh_frame")
+				}
+				f.Fdes = append(f.Fdes, &FdeRecord{
+					ObjFile:  f,
+					Offset:   offset,
+					Contents: data[offset:end],
+					Rels:     rels[begin:relIdx],
+					Cie:      cie,
+				})
+			}
+			offset = end
+		}
+	}
+}
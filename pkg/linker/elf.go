@@ -12,13 +12,68 @@ import (
 const ADDR_BASE uint64 = 0x200000
 const EF_RISCV_RVC uint32 = 1
 const PageSize = 4096
+const SHF_GNU_RETAIN uint64 = 0x200000
+const STT_GNU_IFUNC uint8 = 10
+
+// relocation types newer than debug/elf
+const (
+	R_RISCV_IRELATIVE         elf.R_RISCV = 58
+	R_RISCV_SET_ULEB128       elf.R_RISCV = 60
+	R_RISCV_SUB_ULEB128       elf.R_RISCV = 61
+	R_RISCV_TLSDESC_HI20      elf.R_RISCV = 62
+	R_RISCV_TLSDESC_LOAD_LO12 elf.R_RISCV = 63
+	R_RISCV_TLSDESC_ADD_LO12  elf.R_RISCV = 64
+	R_RISCV_TLSDESC_CALL      elf.R_RISCV = 65
+	R_RISCV_TLSDESC           elf.R_RISCV = 12 // dynamic
+)
+
+// for relative relocations packed into a bitmap, newer than debug/elf
+const SHT_RELR uint32 = 19
+
+const (
+	DT_RELRSZ  elf.DynTag = 35
+	DT_RELR    elf.DynTag = 36
+	DT_RELRENT elf.DynTag = 37
+)
+
+var relTypeNames = map[elf.R_RISCV]string{
+	R_RISCV_IRELATIVE:         "R_RISCV_IRELATIVE",
+	R_RISCV_SET_ULEB128:       "R_RISCV_SET_ULEB128",
+	R_RISCV_SUB_ULEB128:       "R_RISCV_SUB_ULEB128",
+	R_RISCV_TLSDESC_HI20:      "R_RISCV_TLSDESC_HI20",
+	R_RISCV_TLSDESC_LOAD_LO12: "R_RISCV_TLSDESC_LOAD_LO12",
+	R_RISCV_TLSDESC_ADD_LO12:  "R_RISCV_TLSDESC_ADD_LO12",
+	R_RISCV_TLSDESC_CALL:      "R_RISCV_TLSDESC_CALL",
+	R_RISCV_TLSDESC:           "R_RISCV_TLSDESC",
+}
+
+func relTypeName(typ uint32) string {
+	if name, ok := relTypeNames[elf.R_RISCV(typ)]; ok {
+		return name
+	}
+	return elf.R_RISCV(typ).String()
+}
 
 const EhdrSize = int(unsafe.Sizeof(Ehdr{}))
+const DynSize = int(unsafe.Sizeof(Dyn{}))
 const ShdrSize = int(unsafe.Sizeof(Shdr{}))
 const SymSize = int(unsafe.Sizeof(Sym{}))
 const PhdrSize = int(unsafe.Sizeof(Phdr{}))
 const AhdrSize = int(unsafe.Sizeof(ArHdr{}))
 const RelaSize = int(unsafe.Sizeof(Rela{}))
+const VerdefSize = int(unsafe.Sizeof(Verdef{}))
+const VerdauxSize = int(unsafe.Sizeof(Verdaux{}))
+const VerneedSize = int(unsafe.Sizeof(Verneed{}))
+const VernauxSize = int(unsafe.Sizeof(Vernaux{}))
+
+// version indexes of .gnu.version, the others name a version of
+// .gnu.version_d or .gnu.version_r
+const (
+	VER_NDX_LOCAL  uint16 = 0
+	VER_NDX_GLOBAL uint16 = 1
+	VERSYM_HIDDEN  uint16 = 0x8000 // only reachable as foo@VER
+	VER_FLG_BASE   uint16 = 1
+)
 
 type Ehdr struct {
 	Ident     [16]uint8
@@ -77,6 +132,46 @@ type Rela struct {
 	Addend int64
 }
 
+// an entry of .dynamic
+type Dyn struct {
+	Tag int64
+	Val uint64
+}
+
+// an entry of .gnu.version_d, a version defined by the file
+// the first auxiliary entry has the name, the others its parents
+type Verdef struct {
+	Version uint16
+	Flags   uint16
+	Ndx     uint16
+	Cnt     uint16
+	Hash    uint32
+	Aux     uint32 // offset of the first Verdaux from this entry
+	Next    uint32 // offset of the next Verdef from this entry
+}
+
+type Verdaux struct {
+	Name uint32
+	Next uint32
+}
+
+// an entry of .gnu.version_r, the versions needed from a shared object
+type Verneed struct {
+	Version uint16
+	Cnt     uint16
+	File    uint32 // soname in .dynstr
+	Aux     uint32
+	Next    uint32
+}
+
+type Vernaux struct {
+	Hash  uint32
+	Flags uint16
+	Other uint16 // the index used in .gnu.version
+	Name  uint32
+	Next  uint32
+}
+
 func (s *Sym) GetShndx(table []uint32, idx uint32) uint32 {
 	if elf.SectionIndex(s.Shndx) != elf.SHN_XINDEX {
 		return uint32(s.Shndx)
@@ -96,6 +191,18 @@ func (s *Sym) IsCommon() bool {
 	return s.Shndx == uint16(elf.SHN_COMMON)
 }
 
+func (s *Sym) Type() uint8 {
+	return s.Info & 0xf
+}
+
+func (s *Sym) Bind() uint8 {
+	return s.Info >> 4
+}
+
+func (s *Sym) Visibility() uint8 {
+	return s.Other & 0x3
+}
+
 type ArHdr struct {
 	Name [16]byte
 	Date [12]byte
@@ -13,6 +13,8 @@ const (
 	FileTypeEmpty
 	FileTypeObject
 	FileTypeArchive
+	FileTypeSharedObject
+	FileTypeText // linker scripts, e.g. libc.so of glibc
 )
 
 func GetFileTypeFromContent(content []byte) FileType {
@@ -27,6 +29,8 @@ func GetFileTypeFromContent(content []byte) FileType {
 		switch elf.Type(elfType) {
 		case elf.ET_REL:
 			return FileTypeObject
+		case elf.ET_DYN:
+			return FileTypeSharedObject
 		}
 	}
 
@@ -34,6 +38,9 @@ func GetFileTypeFromContent(content []byte) FileType {
 		return FileTypeArchive
 	}
 
+	if utils.IsText(content) {
+		return FileTypeText
+	}
 	return FileTypeUnknown
 }
 
@@ -0,0 +1,256 @@
+package linker
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"strings"
+)
+
+// identical code folding
+// two sections are identical if their contents are the same, and their
+// relocations point to the same places (or to sections that are identical too)
+// classes of sections are refined again and again until nothing changes
+type icfContext struct {
+	Sections   []*InputSection
+	Classes    map[*InputSection]int
+	Candidates map[*InputSection]bool
+	Fdes       map[*InputSection][]*FdeRecord
+}
+
+func isIcfCandidate(ctx *Context, isec *InputSection) bool {
+	flags := isec.Shdr.Flags
+	return isec.IsAlive && flags&uint64(elf.SHF_ALLOC) != 0 &&
+		flags&uint64(elf.SHF_EXECINSTR) != 0 && flags&uint64(elf.SHF_WRITE) == 0 &&
+		isec.Shdr.Type == uint32(elf.SHT_PROGBITS) &&
+		strings.HasPrefix(isec.Name, ".text") && !isGcRoot(ctx, isec)
+}
+
+// only calls and jumps don't take the address of a function,
+// pcrel lo12 points to the auipc in the same section so it doesn't count either
+func isAddressTakingRel(typ uint32) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_NONE, elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT, elf.R_RISCV_JAL,
+		elf.R_RISCV_BRANCH, elf.R_RISCV_RVC_BRANCH, elf.R_RISCV_RVC_JUMP,
+		elf.R_RISCV_RELAX, elf.R_RISCV_ALIGN, elf.R_RISCV_PCREL_LO12_I,
+		elf.R_RISCV_PCREL_LO12_S:
+		return false
+	}
+	return true
+}
+
+// for --icf=safe, sections whose address is used can't be folded,
+// since the program may compare function pointers
+func findAddressTakenSections(ctx *Context) map[*InputSection]bool {
+	ret := make(map[*InputSection]bool)
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsAlive ||
+				isec.Shdr.Flags&uint64(elf.SHF_ALLOC) == 0 {
+				continue
+			}
+			for _, rel := range isec.GetRels() {
+				if !isAddressTakingRel(rel.Type) {
+					continue
+				}
+				if target := file.Symbols[rel.Sym].InputSection; target != nil {
+					ret[target] = true
+				}
+			}
+		}
+	}
+	for _, fde := range getAllFdes(ctx) {
+		for _, rel := range fde.Rels[1:] {
+			if target := fde.ObjFile.Symbols[rel.Sym].InputSection; target != nil {
+				ret[target] = true
+			}
+		}
+	}
+	return ret
+}
+
+// writes where a relocation points to
+// if it points to a candidate, only its class is used, so that
+// mutually recursive functions could be folded as well
+func (c *icfContext) writeRelTarget(buf *bytes.Buffer, file *ObjectFile, rel *Rela, withClass bool) {
+	fmt.Fprintf(buf, "%d:%d:", rel.Type, rel.Addend)
+	if frag, offset := file.GetFragmentOfRel(rel); frag != nil {
+		fmt.Fprintf(buf, "F%p+%d;", frag, offset)
+		return
+	}
+
+	sym := file.Symbols[rel.Sym]
+	switch {
+	case sym.File == nil:
+		fmt.Fprintf(buf, "U%p;", sym)
+	case sym.SectionFragment != nil:
+		fmt.Fprintf(buf, "F%p+%d;", sym.SectionFragment, sym.Value)
+	case sym.InputSection != nil && c.Candidates[sym.InputSection]:
+		if withClass {
+			fmt.Fprintf(buf, "C%d+%d;", c.Classes[sym.InputSection], sym.Value)
+		} else {
+			fmt.Fprintf(buf, "C+%d;", sym.Value)
+		}
+	case sym.InputSection != nil:
+		fmt.Fprintf(buf, "S%p+%d;", sym.InputSection, sym.Value)
+	default:
+		fmt.Fprintf(buf, "A%p;", sym)
+	}
+}
+
+// the part of a section that never changes between rounds,
+// FDEs are included since they hold the LSDA (exception table) pointer
+func (c *icfContext) getKey(isec *InputSection, withClass bool) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%d:%d:%d:%d:", isec.Shdr.Type, isec.Shdr.Flags, isec.SecSize, isec.P2Align)
+	buf.Write(isec.Content)
+
+	rels := isec.GetRels()
+	fmt.Fprintf(buf, "|%d|", len(rels))
+	for idx := range rels {
+		fmt.Fprintf(buf, "%d:", rels[idx].Offset)
+		c.writeRelTarget(buf, isec.ObjFile, &rels[idx], withClass)
+	}
+
+	for _, fde := range c.Fdes[isec] {
+		fmt.Fprintf(buf, "|fde|")
+		buf.Write(fde.Contents[8:])
+		for idx := 1; idx < len(fde.Rels); idx++ {
+			fmt.Fprintf(buf, "%d:", fde.Rels[idx].Offset-fde.Offset)
+			c.writeRelTarget(buf, fde.ObjFile, &fde.Rels[idx], withClass)
+		}
+		fmt.Fprintf(buf, "|cie|")
+		buf.Write(fde.Cie.Contents)
+		for idx := range fde.Cie.Rels {
+			c.writeRelTarget(buf, fde.Cie.ObjFile, &fde.Cie.Rels[idx], false)
+		}
+	}
+	return buf.String()
+}
+
+// returns the number of classes
+func (c *icfContext) assignClasses(withClass bool) int {
+	ids := make(map[string]int)
+	classes := make(map[*InputSection]int)
+	for _, isec := range c.Sections {
+		key := c.getKey(isec, withClass)
+		if withClass {
+			key = fmt.Sprintf("%d|%s", c.Classes[isec], key)
+		}
+		id, ok := ids[key]
+		if !ok {
+			id = len(ids)
+			ids[key] = id
+		}
+		classes[isec] = id
+	}
+	c.Classes = classes
+	return len(ids)
+}
+
+func getAllFdes(ctx *Context) []*FdeRecord {
+	ret := make([]*FdeRecord, 0)
+	for _, file := range ctx.Args.ObjFiles {
+		ret = append(ret, file.Fdes...)
+	}
+	return ret
+}
+
+// should be called after gc, and before offsets of input sections are set
+// the first section of a class is kept, the others are dead,
+// and symbols pointing to them are redirected to the kept one
+func FoldIdenticalSections(ctx *Context) {
+	if ctx.Args.Icf == "none" {
+		return
+	}
+
+	c := &icfContext{
+		Candidates: make(map[*InputSection]bool),
+		Fdes:       make(map[*InputSection][]*FdeRecord),
+	}
+	addressTaken := make(map[*InputSection]bool)
+	if ctx.Args.Icf == "safe" {
+		addressTaken = findAddressTakenSections(ctx)
+	}
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec != nil && isIcfCandidate(ctx, isec) && !addressTaken[isec] {
+				c.Sections = append(c.Sections, isec)
+				c.Candidates[isec] = true
+			}
+		}
+	}
+	for _, fde := range getAllFdes(ctx) {
+		if target := fde.GetTargetSection(); c.Candidates[target] {
+			c.Fdes[target] = append(c.Fdes[target], fde)
+		}
+	}
+
+	num := c.assignClasses(false)
+	for {
+		newNum := c.assignClasses(true)
+		if newNum == num {
+			break
+		}
+		num = newNum
+	}
+
+	leaders := make(map[int]*InputSection)
+	folded := make(map[*InputSection]*InputSection)
+	for _, isec := range c.Sections {
+		leader, ok := leaders[c.Classes[isec]]
+		if !ok {
+			leaders[c.Classes[isec]] = isec
+			continue
+		}
+		folded[isec] = leader
+		isec.IsAlive = false
+	}
+
+	if ctx.Args.PrintIcfSections {
+		for _, leader := range c.Sections {
+			if folded[leader] != nil || leaders[c.Classes[leader]] != leader {
+				continue
+			}
+			printed := false
+			for _, isec := range c.Sections {
+				if folded[isec] != leader {
+					continue
+				}
+				if !printed {
+					fmt.Printf("selected section %s:(%s)\n", leader.ObjFile.GetFileName(), leader.Name)
+					printed = true
+				}
+				fmt.Printf("  removing identical section %s:(%s)\n", isec.ObjFile.GetFileName(), isec.Name)
+			}
+		}
+	}
+
+	// once the section symbol is redirected, the FDE of a folded section
+	// would describe the leader a second time, so it is dropped
+	deadFdes := make(map[*FdeRecord]bool)
+	for isec := range folded {
+		for _, fde := range c.Fdes[isec] {
+			deadFdes[fde] = true
+		}
+	}
+
+	for _, file := range ctx.Args.ObjFiles {
+		fdes := make([]*FdeRecord, 0, len(file.Fdes))
+		for _, fde := range file.Fdes {
+			if !deadFdes[fde] {
+				fdes = append(fdes, fde)
+			}
+		}
+		file.Fdes = fdes
+
+		for _, sym := range file.Symbols {
+			if sym == nil {
+				continue
+			}
+			if leader, ok := folded[sym.InputSection]; ok {
+				sym.SetInputSection(leader)
+			}
+		}
+	}
+}
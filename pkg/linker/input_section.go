@@ -2,6 +2,7 @@ package linker
 
 import (
 	"debug/elf"
+	"fmt"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
 	"strings"
 )
@@ -19,6 +20,12 @@ type InputSection struct {
 	Offset        uint32 // the offset inside output section
 	RelSecIdx     uint32 // corresponding relocation section
 	Rels          []Rela
+	RelaxDeltas   []RelaxDelta // bytes removed by relaxation
+	RelaxRemoved  []int64      // bytes removed for each relocation
+	Thunk         *Thunk       // set if this section is a thunk
+	CallerThunk   *Thunk       // thunk placed right after this section
+	ThunkRefs     map[int]*ThunkEntry
+	DynRels       []int // relocations applied by the dynamic loader, R_RISCV_64
 }
 
 func NewInputSection(obj *ObjectFile, content []byte, shndx uint32, shdr *Shdr, name string) *InputSection {
@@ -86,6 +93,7 @@ func (i *InputSection) GetOutputSectionName() string {
 
 	var prefixes = []string{
 		".text", ".data.rel.ro", ".data", ".rodata", ".bss.rel.ro", ".bss",
+		".sdata", ".sbss", ".srodata",
 		".init_array", ".fini_array", ".tbss", ".tdata", ".gcc_except_table",
 		".ctors", ".dtors",
 	}
@@ -103,7 +111,16 @@ func (i *InputSection) WriteTo(ctx *Context, buf []byte) {
 	if i.Shdr.Type == uint32(elf.SHT_NOBITS) || i.SecSize == 0 {
 		return
 	}
-	copy(buf, i.Content)
+	if i.Thunk != nil {
+		i.Thunk.WriteTo(ctx, buf)
+		return
+	}
+
+	if len(i.RelaxDeltas) > 0 {
+		i.copyRelaxedContent(buf)
+	} else {
+		copy(buf, i.Content)
+	}
 
 	if i.Shdr.Flags&uint64(elf.SHF_ALLOC) != 0 {
 		i.ApplyRelocAlloc(ctx, buf)
@@ -127,13 +144,160 @@ func (i *InputSection) GetAddr() uint64 {
 	return i.OutputSection.Shdr.Addr + uint64(i.Offset)
 }
 
-func (i *InputSection) ScanRelsFindGotSyms() {
-	for _, rel := range i.GetRels() {
+func (i *InputSection) ScanRelsFindGotSyms(ctx *Context) {
+	rels := i.GetRels()
+	for idx := range rels {
+		rel := &rels[idx]
 		sym := i.ObjFile.Symbols[rel.Sym]
-		if rel.Type == uint32(elf.R_RISCV_TLS_GOT_HI20) {
-			sym.Flags |= IsInGot
+		// whatever the relocation is, an ifunc is reached through its stub
+		if rel.Type != uint32(elf.R_RISCV_NONE) && sym.usesIplt(ctx) {
+			sym.Flags |= NeedsPlt
+		}
+		if sym.IsPreemptible(ctx) {
+			i.scanPreemptibleSymbol(ctx, idx, rel, sym)
+		} else if ctx.IsPic() {
+			i.scanPicReloc(ctx, idx, rel)
+		}
+		switch elf.R_RISCV(rel.Type) {
+		case elf.R_RISCV_GOT_HI20:
+			sym.Flags |= NeedsGot
+		case elf.R_RISCV_TLS_GOT_HI20:
+			sym.Flags |= NeedsGotTp
+		case elf.R_RISCV_TLS_GD_HI20:
+			sym.Flags |= NeedsTlsGd
+		case R_RISCV_TLSDESC_HI20:
+			// the tls block of a shared object is not at a known offset
+			// from tp, and may be allocated on dlopen, so the descriptor
+			// is kept for the dynamic loader
+			// in an executable, relaxed to initial exec without a definition
+			if ctx.Args.Shared {
+				sym.Flags |= NeedsTlsDesc
+			} else if sym.File == nil || sym.IsPreemptible(ctx) {
+				sym.Flags |= NeedsGotTp
+			}
+		}
+	}
+}
+
+// a preemptible symbol is reached through the got, a plt stub for calls,
+// or a dynamic relocation, in an executable the data of a shared object
+// can also be copied into the output
+func (i *InputSection) scanPreemptibleSymbol(ctx *Context, idx int, rel *Rela, sym *Symbol) {
+	sym.Flags |= NeedsDynsym
+	switch elf.R_RISCV(rel.Type) {
+	case elf.R_RISCV_NONE, elf.R_RISCV_RELAX, elf.R_RISCV_ALIGN,
+		elf.R_RISCV_GOT_HI20, elf.R_RISCV_TLS_GOT_HI20, elf.R_RISCV_TLS_GD_HI20,
+		R_RISCV_TLSDESC_HI20, R_RISCV_TLSDESC_LOAD_LO12, R_RISCV_TLSDESC_ADD_LO12,
+		R_RISCV_TLSDESC_CALL:
+	case elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT, elf.R_RISCV_JAL, elf.R_RISCV_BRANCH,
+		elf.R_RISCV_RVC_JUMP, elf.R_RISCV_RVC_BRANCH:
+		sym.Flags |= NeedsPlt
+	case elf.R_RISCV_TPREL_HI20, elf.R_RISCV_TPREL_LO12_I, elf.R_RISCV_TPREL_LO12_S,
+		elf.R_RISCV_TPREL_ADD:
+		i.fatalRecompileWithPic(ctx, rel)
+	case elf.R_RISCV_64:
+		if ctx.IsPic() {
+			i.addDynRel(ctx, idx, rel)
+			break
 		}
+		i.scanAddressTaken(ctx, rel, sym)
+	default:
+		i.scanAddressTaken(ctx, rel, sym)
+	}
+}
+
+// the address is taken, it has to be the same everywhere
+// a shared object cannot provide a copy or a canonical plt
+func (i *InputSection) scanAddressTaken(ctx *Context, rel *Rela, sym *Symbol) {
+	if ctx.Args.Shared || !sym.IsImported() ||
+		(ctx.IsPic() && isAbsReloc(rel.Type)) {
+		i.fatalRecompileWithPic(ctx, rel)
+	}
+	typ := sym.GetElfSym().Type()
+	if typ == uint8(elf.STT_FUNC) || typ == STT_GNU_IFUNC {
+		sym.Flags |= NeedsPlt | NeedsCanonicalPlt
+	} else {
+		sym.Flags |= NeedsCopyrel
+	}
+}
+
+// a position independent output is loaded at an unknown address, so
+// absolute addresses are relocated by the dynamic loader (R_RISCV_RELATIVE)
+// and cannot be put into instructions
+func (i *InputSection) scanPicReloc(ctx *Context, idx int, rel *Rela) {
+	switch {
+	case rel.Type == uint32(elf.R_RISCV_64):
+		if i.isRelocRelative(ctx, rel) {
+			i.addDynRel(ctx, idx, rel)
+		}
+	case isAbsReloc(rel.Type):
+		if i.isRelocRelative(ctx, rel) {
+			i.fatalRecompileWithPic(ctx, rel)
+		}
+	case isTprelReloc(rel.Type):
+		// local exec, the tls block of a shared object has no fixed offset
+		if ctx.Args.Shared {
+			i.fatalRecompileWithPic(ctx, rel)
+		}
+	}
+}
+
+// the value is an absolute address that doesn't fit into 64 bits
+// or is written into instructions, the dynamic loader cannot fix it
+func isAbsReloc(typ uint32) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_32, elf.R_RISCV_HI20, elf.R_RISCV_LO12_I, elf.R_RISCV_LO12_S,
+		elf.R_RISCV_RVC_LUI:
+		return true
 	}
+	return false
+}
+
+func isTprelReloc(typ uint32) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_TPREL_HI20, elf.R_RISCV_TPREL_LO12_I, elf.R_RISCV_TPREL_LO12_S,
+		elf.R_RISCV_TPREL_ADD:
+		return true
+	}
+	return false
+}
+
+// whether the value moves with the load address, absolute symbols and
+// undefined weak ones (zero) don't, linker defined ones do
+func (i *InputSection) isRelocRelative(ctx *Context, rel *Rela) bool {
+	if frag, _ := i.ObjFile.GetFragmentOfRel(rel); frag != nil {
+		return true
+	}
+	sym := i.ObjFile.Symbols[rel.Sym]
+	if sym.File == nil {
+		return false
+	}
+	if sym.File == ctx.InternalObj || sym.InputSection != nil || sym.SectionFragment != nil {
+		return true
+	}
+	return !sym.GetElfSym().IsAbs()
+}
+
+// the dynamic loader writes into the section, it has to be writable
+func (i *InputSection) addDynRel(ctx *Context, idx int, rel *Rela) {
+	if i.Shdr.Flags&uint64(elf.SHF_WRITE) == 0 {
+		utils.Fatal(fmt.Sprintf("%s: relocation %s against %s in read-only section; recompile with %s",
+			i.getRelLocation(rel), relTypeName(rel.Type), i.getRelSymbolName(rel), picFlag(ctx)))
+	}
+	i.DynRels = append(i.DynRels, idx)
+}
+
+// executables are compiled with -fPIE, shared objects with -fPIC
+func picFlag(ctx *Context) string {
+	if ctx.Args.Pie {
+		return "-fPIE"
+	}
+	return "-fPIC"
+}
+
+func (i *InputSection) fatalRecompileWithPic(ctx *Context, rel *Rela) {
+	utils.Fatal(fmt.Sprintf("%s: relocation %s against %s cannot be used in a position independent output or with a symbol of a shared object; recompile with %s",
+		i.getRelLocation(rel), relTypeName(rel.Type), i.getRelSymbolName(rel), picFlag(ctx)))
 }
 
 // relocations can be divided into multiple kinds,
@@ -141,6 +305,12 @@ func (i *InputSection) ScanRelsFindGotSyms() {
 // base is the starting address of the section
 func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 	rels := i.GetRels()
+	// offsets of got_hi20 relaxed to pc relative
+	gotRelaxed := make(map[uint64]bool)
+	// tlsdesc_hi20 by offset, the other relocations of the sequence point to it
+	tlsdesc := make(map[uint64]*tlsdescSequence)
+	// targets of hi20 whose lui was removed, their lo12 reach them from gp
+	luiRemoved := make(map[uint64]bool)
 
 	for a := 0; a < len(rels); a++ {
 		rel := rels[a]
@@ -150,39 +320,134 @@ func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 		}
 
 		sym := i.ObjFile.Symbols[rel.Sym]
-		loc := base[rel.Offset:]
+		offset := i.GetRelaxedOffset(rel.Offset)
+		loc := base[offset:]
 
-		if sym.File == nil {
-			continue
+		// undefined symbols (weak, or ignored by --unresolved-symbols)
+		// have no file and their address is zero, unless a shared object
+		// calls them through the plt
+		isUndef := sym.File == nil && sym.Flags&NeedsPlt == 0
+
+		S, A := i.ObjFile.GetRelSymbolAddrAndAddend(ctx, &rel)
+		P := i.GetAddr() + offset
+
+		// too far away, jump to the thunk instead
+		if entry, ok := i.ThunkRefs[a]; ok {
+			S, A = entry.GetAddr(), 0
 		}
 
-		S := sym.GetAddr()
-		A := uint64(rel.Addend)
-		P := i.GetAddr() + rel.Offset
+		// shortened by relaxation, the instruction is written from scratch
+		if len(i.RelaxRemoved) > 0 && i.RelaxRemoved[a] != 0 {
+			if rel.Type == uint32(elf.R_RISCV_HI20) && i.RelaxRemoved[a] == 4 {
+				luiRemoved[S+A] = true
+			}
+			i.writeRelaxedInstr(rels, a, loc, S+A, P)
+			continue
+		}
 
 		switch elf.R_RISCV(rel.Type) {
+		case elf.R_RISCV_ALIGN, elf.R_RISCV_PCREL_LO12_I, elf.R_RISCV_PCREL_LO12_S:
+			// nops kept by relaxation, and lo12 is handled below
 		case elf.R_RISCV_32:
+			i.checkRange(&rel, S+A, -(1 << 31), 1<<32)
 			utils.Write[uint32](loc, uint32(S+A))
 		case elf.R_RISCV_64:
 			utils.Write[uint64](loc, S+A)
 		case elf.R_RISCV_BRANCH:
 			// pc relative offset
+			// branching to an undefined symbol makes no sense, branch to itself
+			if isUndef {
+				writeBtype(loc, 0)
+				break
+			}
+			i.checkRange(&rel, S+A-P, -(1 << 12), 1<<12)
+			i.checkAlign(&rel, S+A-P, 2)
 			writeBtype(loc, uint32(S+A-P))
 		case elf.R_RISCV_JAL:
 			// pc relative offset
+			// jump to itself if undefined, easier to debug than jumping to zero
+			if isUndef {
+				writeJtype(loc, 0)
+				break
+			}
+			i.checkRange(&rel, S+A-P, -(1 << 20), 1<<20)
+			i.checkAlign(&rel, S+A-P, 2)
 			writeJtype(loc, uint32(S+A-P))
+		case elf.R_RISCV_RVC_BRANCH:
+			// c.beqz and c.bnez, pc relative offset
+			if isUndef {
+				writeCBtype(loc, 0)
+				break
+			}
+			i.checkRange(&rel, S+A-P, -(1 << 8), 1<<8)
+			i.checkAlign(&rel, S+A-P, 2)
+			writeCBtype(loc, uint16(S+A-P))
+		case elf.R_RISCV_RVC_JUMP:
+			// c.j, pc relative offset
+			if isUndef {
+				writeCJtype(loc, 0)
+				break
+			}
+			i.checkRange(&rel, S+A-P, -(1 << 11), 1<<11)
+			i.checkAlign(&rel, S+A-P, 2)
+			writeCJtype(loc, uint16(S+A-P))
+		case elf.R_RISCV_RVC_LUI:
+			// c.lui only holds 6 bits of hi20
+			// c.lui with zero is reserved, c.li rd, 0 is written instead,
+			// e.g. for weak undefined symbols, which are not widened
+			hi := uint64(int64(S+A+0x800) >> 12)
+			if hi == 0 {
+				rd := utils.Bits(utils.ReadWithReturn[uint16](loc), 11, 7)
+				utils.Write[uint16](loc, 0x4001|rd<<7)
+				break
+			}
+			i.checkRange(&rel, hi, -(1 << 5), 1<<5)
+			writeCLUItype(loc, uint16(hi))
 		case elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT:
 			// call uses auipc and jalr to jump to a function
 			// needs a register as a base so use jalr instead of jal
 			// R_RISCV_CALL is now deprecated, R_RISCV_CALL_PLT only
+			// like jal, calling an undefined symbol becomes a jump to itself
 			val := uint32(S + A - P)
+			if isUndef {
+				val = 0
+			} else {
+				i.checkHi20Range(&rel, S+A-P)
+			}
 			writeUtype(loc, val)
 			writeItype(loc[4:], val)
+		case elf.R_RISCV_GOT_HI20:
+			// the address is known, so auipc + ld becomes auipc + addi
+			// and the value is not loaded from the got
+			if i.isGotRelaxable(ctx, rels, a, S+A-P) {
+				gotRelaxed[offset] = true
+				i.checkHi20Range(&rel, S+A-P)
+				utils.Write[uint32](loc, uint32(S+A-P))
+				break
+			}
+			i.checkHi20Range(&rel, sym.GetGotAddr(ctx)+A-P)
+			utils.Write[uint32](loc, uint32(sym.GetGotAddr(ctx)+A-P))
 		case elf.R_RISCV_TLS_GOT_HI20:
-			utils.Write[uint32](loc, uint32(sym.GetGotEntryAddr(ctx)+A-P))
+			i.checkHi20Range(&rel, sym.GetGotTpAddr(ctx)+A-P)
+			utils.Write[uint32](loc, uint32(sym.GetGotTpAddr(ctx)+A-P))
+		case elf.R_RISCV_TLS_GD_HI20:
+			// the address of the pair passed to __tls_get_addr
+			// local dynamic uses this too, against a local symbol
+			i.checkHi20Range(&rel, sym.GetTlsGdAddr(ctx)+A-P)
+			utils.Write[uint32](loc, uint32(sym.GetTlsGdAddr(ctx)+A-P))
 		case elf.R_RISCV_PCREL_HI20:
+			// the pair should yield zero (plus addend), so the value is absolute,
+			// and auipc is turned into lui later
+			if isUndef {
+				i.checkHi20Range(&rel, S+A)
+				utils.Write[uint32](loc, uint32(S+A))
+				break
+			}
+			i.checkHi20Range(&rel, S+A-P)
 			utils.Write[uint32](loc, uint32(S+A-P))
 		case elf.R_RISCV_HI20: // %high(symbol)
+			// under medlow the symbol has to be in the lowest or highest 2GiB
+			i.checkHi20Range(&rel, S+A)
 			writeUtype(loc, uint32(S+A))
 		case elf.R_RISCV_LO12_I, elf.R_RISCV_LO12_S:
 			val := S + A
@@ -194,7 +459,60 @@ func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 
 			if utils.SignExtend(val, 11) == val {
 				setRs1(loc, 0)
+				break
+			}
+
+			// lui was removed by relaxation, reach the symbol from gp
+			// whether the lo12 itself is marked doesn't matter
+			if luiRemoved[val] {
+				gp := ctx.SymbolMap["__global_pointer$"].GetAddr()
+				if rel.Type == uint32(elf.R_RISCV_LO12_I) {
+					writeItype(loc, uint32(val-gp))
+				} else {
+					writeStype(loc, uint32(val-gp))
+				}
+				setRs1(loc, 3)
+			}
+		case R_RISCV_TLSDESC_HI20:
+			seq := &tlsdescSequence{
+				IsDesc:      ctx.Args.Shared,
+				IsLocalExec: sym.File != nil && !sym.IsPreemptible(ctx) && !ctx.Args.Shared,
+				TpOffset:    S + A - ctx.TLSSegmentAddr,
 			}
+			tlsdesc[offset] = seq
+			if seq.IsDesc {
+				// auipc a0, %pcrel_hi(descriptor)
+				seq.GotOffset = sym.GetTlsDescAddr(ctx) - P
+				i.checkHi20Range(&rel, seq.GotOffset)
+				writeUtype(loc, uint32(seq.GotOffset))
+				break
+			}
+			if seq.IsLocalExec {
+				i.checkHi20Range(&rel, seq.TpOffset)
+				utils.Write[uint32](loc, nop)
+				break
+			}
+			// auipc a0, %got_pcrel_hi(tp offset)
+			seq.GotOffset = sym.GetGotTpAddr(ctx) - P
+			i.checkHi20Range(&rel, seq.GotOffset)
+			utils.Write[uint32](loc, 0x17|10<<7|utype(uint32(seq.GotOffset)))
+		case R_RISCV_TLSDESC_LOAD_LO12, R_RISCV_TLSDESC_ADD_LO12, R_RISCV_TLSDESC_CALL:
+			seq, ok := tlsdesc[sym.Value]
+			utils.Assert(sym.InputSection == i && ok)
+			if seq.IsDesc {
+				// ld and addi get the low bits, the call stays
+				if rel.Type != uint32(R_RISCV_TLSDESC_CALL) {
+					writeItype(loc, uint32(seq.GotOffset))
+				}
+				break
+			}
+			utils.Write[uint32](loc, seq.relax(elf.R_RISCV(rel.Type)))
+		case elf.R_RISCV_TPREL_HI20:
+			// lui rd, %tprel_hi(symbol)
+			i.checkHi20Range(&rel, S+A-ctx.TLSSegmentAddr)
+			writeUtype(loc, uint32(S+A-ctx.TLSSegmentAddr))
+		case elf.R_RISCV_TPREL_ADD:
+			// add rd, rd, tp, only a mark for relaxation
 		case elf.R_RISCV_TPREL_LO12_I, elf.R_RISCV_TPREL_LO12_S:
 			val := S + A - ctx.TLSSegmentAddr
 			if rel.Type == uint32(elf.R_RISCV_TPREL_LO12_I) {
@@ -206,6 +524,25 @@ func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 			if utils.SignExtend(val, 11) == val {
 				setRs1(loc, 4)
 			}
+		case R_RISCV_SET_ULEB128:
+			// usually followed by SUB_ULEB128 at the same place, only the
+			// difference has to fit
+			val := S + A
+			if a+1 < len(rels) && rels[a+1].Type == uint32(R_RISCV_SUB_ULEB128) &&
+				rels[a+1].Offset == rel.Offset {
+				S2, A2 := i.ObjFile.GetRelSymbolAddrAndAddend(ctx, &rels[a+1])
+				val -= S2 + A2
+				a++
+			}
+			i.writeUleb(&rel, loc, val)
+		case R_RISCV_SUB_ULEB128:
+			val, _ := utils.ReadUleb(loc)
+			i.writeUleb(&rel, loc, val-(S+A))
+		default:
+			if !applyDataReloc(loc, rel.Type, S, A, P) {
+				utils.Fatal(fmt.Sprintf("%s: unknown relocation %s against %s",
+					i.getRelLocation(&rel), relTypeName(rel.Type), i.getRelSymbolName(&rel)))
+			}
 		}
 	}
 
@@ -216,10 +553,14 @@ func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 		case elf.R_RISCV_PCREL_LO12_I, elf.R_RISCV_PCREL_LO12_S:
 			sym := i.ObjFile.Symbols[rels[a].Sym]
 			utils.Assert(sym.InputSection == i)
-			loc := base[rels[a].Offset:]
+			loc := base[i.GetRelaxedOffset(rels[a].Offset):]
 			val := utils.ReadWithReturn[uint32](base[sym.Value:])
 
 			if rels[a].Type == uint32(elf.R_RISCV_PCREL_LO12_I) {
+				// ld rd, lo(rs1) => addi rd, rs1, lo
+				if gotRelaxed[sym.Value] {
+					utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)&^0x707f|0x13)
+				}
 				writeItype(loc, val)
 			} else {
 				writeStype(loc, val)
@@ -229,15 +570,167 @@ func (i *InputSection) ApplyRelocAlloc(ctx *Context, base []byte) {
 
 	for a := 0; a < len(rels); a++ {
 		switch elf.R_RISCV(rels[a].Type) {
-		case elf.R_RISCV_PCREL_HI20, elf.R_RISCV_TLS_GOT_HI20:
-			loc := base[rels[a].Offset:]
+		case elf.R_RISCV_PCREL_HI20, elf.R_RISCV_GOT_HI20, elf.R_RISCV_TLS_GOT_HI20,
+			elf.R_RISCV_TLS_GD_HI20:
+			loc := base[i.GetRelaxedOffset(rels[a].Offset):]
 			val := utils.ReadWithReturn[uint32](loc)
 			utils.Write[uint32](loc, utils.ReadWithReturn[uint32](i.Content[rels[a].Offset:]))
 			writeUtype(loc, val)
+
+			// auipc rd, hi => lui rd, hi
+			if rels[a].Type == uint32(elf.R_RISCV_PCREL_HI20) &&
+				i.ObjFile.Symbols[rels[a].Sym].File == nil {
+				utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)&^0x7f|0x37)
+			}
 		}
 	}
 }
 
+// a tls descriptor sequence, there is no resolver in a static executable
+//
+//	auipc a0, %tlsdesc_hi(symbol)
+//	ld    t0, %tlsdesc_load_lo(label)(a0)
+//	addi  a0, a0, %tlsdesc_add_lo(label)
+//	jalr  t0, 0(t0), %tlsdesc_call(label)
+//
+// a0 ends up with the tp offset, which is known if the symbol is
+// defined (local exec), otherwise it is loaded from the got (initial exec)
+type tlsdescSequence struct {
+	IsDesc      bool // kept, the descriptor is in the got
+	IsLocalExec bool
+	TpOffset    uint64
+	GotOffset   uint64 // got entry - auipc
+}
+
+const nop uint32 = 0x00000013
+
+func (t *tlsdescSequence) relax(typ elf.R_RISCV) uint32 {
+	if !t.IsLocalExec {
+		switch typ {
+		case R_RISCV_TLSDESC_ADD_LO12:
+			// ld a0, lo(a0)
+			return 0x3003 | 10<<7 | 10<<15 | itype(uint32(t.GotOffset))
+		default:
+			return nop
+		}
+	}
+
+	small := isInt(t.TpOffset, 12)
+	switch typ {
+	case R_RISCV_TLSDESC_ADD_LO12:
+		if small {
+			return nop
+		}
+		// lui a0, hi
+		return 0x37 | 10<<7 | utype(uint32(t.TpOffset))
+	case R_RISCV_TLSDESC_CALL:
+		if small {
+			// addi a0, zero, lo
+			return 0x13 | 10<<7 | itype(uint32(t.TpOffset))
+		}
+		// addi a0, a0, lo
+		return 0x13 | 10<<7 | 10<<15 | itype(uint32(t.TpOffset))
+	default:
+		return nop
+	}
+}
+
+// only symbols defined in the output have a known address,
+// and auipc + addi reaches ±2GiB
+func (i *InputSection) isGotRelaxable(ctx *Context, rels []Rela, idx int, val uint64) bool {
+	sym := i.ObjFile.Symbols[rels[idx].Sym]
+	return ctx.Args.Relax && hasRelaxMark(rels, idx) && sym.File != nil &&
+		!sym.IsPreemptible(ctx) && (!ctx.IsPic() || i.isRelocRelative(ctx, &rels[idx])) &&
+		isInt(val+0x800, 32)
+}
+
+// values that don't fit into the instruction are reported instead of truncated
+func (i *InputSection) checkRange(rel *Rela, val uint64, lo, hi int64) {
+	if v := int64(val); v < lo || v >= hi {
+		utils.Fatal(fmt.Sprintf("%s: relocation %s against %s out of range: %d is not in [%d, %d]",
+			i.getRelLocation(rel), relTypeName(rel.Type), i.getRelSymbolName(rel), v, lo, hi-1))
+	}
+}
+
+func (i *InputSection) checkAlign(rel *Rela, val uint64, align uint64) {
+	if val%align != 0 {
+		utils.Fatal(fmt.Sprintf("%s: relocation %s against %s is not aligned: %d is not a multiple of %d",
+			i.getRelLocation(rel), relTypeName(rel.Type), i.getRelSymbolName(rel), int64(val), align))
+	}
+}
+
+// hi20 is rounded with the sign of lo12, so the pair reaches [-2GiB-2KiB, 2GiB-2KiB)
+func (i *InputSection) checkHi20Range(rel *Rela, val uint64) {
+	i.checkRange(rel, val, -(1<<31)-0x800, (1<<31)-0x800)
+}
+
+// e.g. a.o:(.text+0x8)
+func (i *InputSection) getRelLocation(rel *Rela) string {
+	return fmt.Sprintf("%s:(%s+0x%x)", i.ObjFile.GetFileName(), i.Name, rel.Offset)
+}
+
+func (i *InputSection) getRelSymbolName(rel *Rela) string {
+	sym := i.ObjFile.Symbols[rel.Sym]
+	if sym.Name == "" && sym.InputSection != nil {
+		return "section " + sym.InputSection.Name
+	}
+	return "`" + sym.Name + "`"
+}
+
+// e.g. in .gcc_except_table, the uleb128 keeps the length it has in the
+// object file, so that the bytes after it don't move
+func (i *InputSection) writeUleb(rel *Rela, loc []byte, val uint64) {
+	if !utils.WriteUlebInPlace(loc, val) {
+		_, n := utils.ReadUleb(loc)
+		utils.Fatal(fmt.Sprintf("%s: relocation %s against %s out of range: %d does not fit in %d bytes",
+			i.getRelLocation(rel), relTypeName(rel.Type), i.getRelSymbolName(rel), val, n))
+	}
+}
+
+// relocations of data, e.g. in .eh_frame, .gcc_except_table and jump tables
+// add/sub/set pairs are used since relaxation may change the distance of two labels
+// returns false if typ is not a data relocation
+func applyDataReloc(loc []byte, typ uint32, S, A, P uint64) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_NONE:
+	case elf.R_RISCV_32:
+		utils.Write[uint32](loc, uint32(S+A))
+	case elf.R_RISCV_64:
+		utils.Write[uint64](loc, S+A)
+	case elf.R_RISCV_32_PCREL:
+		utils.Write[uint32](loc, uint32(S+A-P))
+	case elf.R_RISCV_ADD8:
+		loc[0] += uint8(S + A)
+	case elf.R_RISCV_ADD16:
+		utils.Write[uint16](loc, utils.ReadWithReturn[uint16](loc)+uint16(S+A))
+	case elf.R_RISCV_ADD32:
+		utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)+uint32(S+A))
+	case elf.R_RISCV_ADD64:
+		utils.Write[uint64](loc, utils.ReadWithReturn[uint64](loc)+S+A)
+	case elf.R_RISCV_SUB6:
+		loc[0] = loc[0]&0xc0 | (loc[0]-uint8(S+A))&0x3f
+	case elf.R_RISCV_SUB8:
+		loc[0] -= uint8(S + A)
+	case elf.R_RISCV_SUB16:
+		utils.Write[uint16](loc, utils.ReadWithReturn[uint16](loc)-uint16(S+A))
+	case elf.R_RISCV_SUB32:
+		utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)-uint32(S+A))
+	case elf.R_RISCV_SUB64:
+		utils.Write[uint64](loc, utils.ReadWithReturn[uint64](loc)-(S+A))
+	case elf.R_RISCV_SET6:
+		loc[0] = loc[0]&0xc0 | uint8(S+A)&0x3f
+	case elf.R_RISCV_SET8:
+		loc[0] = uint8(S + A)
+	case elf.R_RISCV_SET16:
+		utils.Write[uint16](loc, uint16(S+A))
+	case elf.R_RISCV_SET32:
+		utils.Write[uint32](loc, uint32(S+A))
+	default:
+		return false
+	}
+	return true
+}
+
 func itype(val uint32) uint32 {
 	return val << 20
 }
@@ -298,7 +791,24 @@ func writeJtype(loc []byte, val uint32) {
 	utils.Write[uint32](loc, (utils.ReadWithReturn[uint32](loc)&mask)|jtype(val))
 }
 
+func writeCBtype(loc []byte, val uint16) {
+	mask := uint16(0b111_000_111_00000_11)
+	utils.Write[uint16](loc, (utils.ReadWithReturn[uint16](loc)&mask)|cbtype(val))
+}
+
+func writeCJtype(loc []byte, val uint16) {
+	mask := uint16(0b111_00000000000_11)
+	utils.Write[uint16](loc, (utils.ReadWithReturn[uint16](loc)&mask)|cjtype(val))
+}
+
+// c.lui rd, nzimm[17:12]
+func writeCLUItype(loc []byte, hi uint16) {
+	mask := uint16(0b111_0_11111_00000_11)
+	utils.Write[uint16](loc, (utils.ReadWithReturn[uint16](loc)&mask)|
+		utils.Bit(hi, 5)<<12|utils.Bits(hi, 4, 0)<<2)
+}
+
 func setRs1(loc []byte, rs1 uint32) {
-	utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)&0b111111_11111_00000_111_11111_1111111)
+	utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)&0b1111111_11111_00000_111_11111_1111111)
 	utils.Write[uint32](loc, utils.ReadWithReturn[uint32](loc)|(rs1<<15))
 }
@@ -0,0 +1,136 @@
+package linker
+
+import (
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"os"
+	"strings"
+)
+
+// libc.so of glibc is a linker script naming the real files, e.g.
+//
+//	GROUP ( /lib/libc.so.6 /usr/lib/libc_nonshared.a AS_NEEDED ( /lib/ld-linux-riscv64-lp64d.so.1 ) )
+//
+// only INPUT, GROUP and AS_NEEDED are read, other commands are skipped
+// version scripts are split into tokens the same way
+func tokenizeScript(content string) []string {
+	tokens := make([]string, 0)
+	for len(content) > 0 {
+		if strings.HasPrefix(content, "/*") {
+			end := strings.Index(content[2:], "*/")
+			if end < 0 {
+				utils.Fatal("unterminated comment in linker script")
+			}
+			content = content[end+4:]
+			continue
+		}
+		// version scripts also have line comments
+		if content[0] == '#' {
+			end := strings.IndexByte(content, '\n')
+			if end < 0 {
+				end = len(content)
+			}
+			content = content[end:]
+			continue
+		}
+		switch c := content[0]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			content = content[1:]
+		case strings.IndexByte("(),;{}", c) >= 0:
+			tokens = append(tokens, content[:1])
+			content = content[1:]
+		case c == ':' && len(tokens) > 0 &&
+			(tokens[len(tokens)-1] == "global" || tokens[len(tokens)-1] == "local"):
+			tokens = append(tokens, ":")
+			content = content[1:]
+		case c == '"':
+			// quoted names may have spaces, e.g. "foo(int, char)"
+			end := strings.IndexByte(content[1:], '"')
+			if end < 0 {
+				utils.Fatal("unterminated string in linker script")
+			}
+			tokens = append(tokens, content[:end+2])
+			content = content[end+2:]
+		default:
+			end := strings.IndexAny(content, " \t\r\n(),;{}")
+			if end < 0 {
+				end = len(content)
+			}
+			// global: and local: in version scripts, but ns::foo is one name
+			for _, scope := range []string{"global", "local"} {
+				if strings.HasPrefix(content[:end], scope+":") {
+					end = len(scope)
+				}
+			}
+			tokens = append(tokens, content[:end])
+			content = content[end:]
+		}
+	}
+	return tokens
+}
+
+func (c *Context) ReadLinkerScript(file *File, asNeeded bool) {
+	tokens := tokenizeScript(string(file.Content))
+	for len(tokens) > 0 {
+		cmd := tokens[0]
+		tokens = tokens[1:]
+		if cmd == ";" {
+			continue
+		}
+		if len(tokens) == 0 || tokens[0] != "(" {
+			utils.Fatal(file.Name + ": unknown linker script command " + cmd)
+		}
+		switch cmd {
+		case "INPUT", "GROUP":
+			tokens = c.readScriptFiles(file, tokens[1:], asNeeded)
+		default:
+			// e.g. OUTPUT_FORMAT(elf64-littleriscv)
+			for len(tokens) > 0 && tokens[0] != ")" {
+				tokens = tokens[1:]
+			}
+			if len(tokens) == 0 {
+				utils.Fatal(file.Name + ": missing ) in linker script")
+			}
+			tokens = tokens[1:]
+		}
+	}
+}
+
+// reads the files until the closing parenthesis
+func (c *Context) readScriptFiles(script *File, tokens []string, asNeeded bool) []string {
+	for len(tokens) > 0 && tokens[0] != ")" {
+		tok := tokens[0]
+		tokens = tokens[1:]
+		switch {
+		case tok == ",":
+		case tok == "AS_NEEDED":
+			if len(tokens) == 0 || tokens[0] != "(" {
+				utils.Fatal(script.Name + ": AS_NEEDED without (")
+			}
+			tokens = c.readScriptFiles(script, tokens[1:], true)
+		case strings.HasPrefix(tok, "-l"):
+			c.ReadFile(c.FindLibrary(tok[2:], c.Args.Static), false, asNeeded)
+		default:
+			c.ReadFile(c.findScriptFile(script, tok), true, asNeeded)
+		}
+	}
+	if len(tokens) == 0 {
+		utils.Fatal(script.Name + ": missing ) in linker script")
+	}
+	return tokens[1:]
+}
+
+// a path is used as it is, otherwise it's searched in the -L paths
+func (c *Context) findScriptFile(script *File, name string) *File {
+	if _, err := os.Stat(name); err == nil {
+		return NewFile(name)
+	}
+	if !strings.HasPrefix(name, "/") {
+		for _, path := range c.Args.LibraryPaths {
+			if file := NewFileNoFatal(path + "/" + name); file != nil {
+				return file
+			}
+		}
+	}
+	utils.Fatal(script.Name + ": cannot find " + name)
+	return nil
+}
@@ -27,7 +27,7 @@ func (m *MachineType) String() string {
 func GetMachineTypeFromContent(content []byte) MachineType {
 	fileType := GetFileTypeFromContent(content)
 	switch fileType {
-	case FileTypeObject:
+	case FileTypeObject, FileTypeSharedObject:
 		var machineType uint16
 		utils.Read[uint16](content[18:], &machineType)
 		switch elf.Machine(machineType) {
@@ -0,0 +1,55 @@
+package linker
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"os"
+)
+
+// -Map writes where every output section, input section and thunk
+// entry ended up, -M prints the same to stdout
+func PrintMap(ctx *Context) {
+	if ctx.Args.Map == "" {
+		return
+	}
+
+	out := os.Stdout
+	if ctx.Args.Map != "-" {
+		file, err := os.Create(ctx.Args.Map)
+		utils.MustNo(err)
+		defer file.Close()
+		out = file
+	}
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "%16s %8s %5s %-7s %-7s %s\n", "VMA", "Size", "Align", "Out", "In", "Symbol")
+	for _, o := range ctx.OutputWriters {
+		shdr := o.GetShdr()
+		if shdr.Flags&uint64(elf.SHF_ALLOC) == 0 || o.GetName() == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%16x %8x %5d %s\n", shdr.Addr, shdr.Size, shdr.AddrAlign, o.GetName())
+
+		osec, ok := o.(*OutputSection)
+		if !ok {
+			continue
+		}
+		for _, isec := range osec.InputSections {
+			if !isec.IsAlive {
+				continue
+			}
+			fmt.Fprintf(w, "%16x %8x %5d         %s:(%s)\n", isec.GetAddr(), isec.SecSize,
+				uint64(1)<<isec.P2Align, isec.ObjFile.GetFileName(), isec.Name)
+			if isec.Thunk == nil {
+				continue
+			}
+			for _, e := range isec.Thunk.Entries {
+				fmt.Fprintf(w, "%16x %8x %5d                 %s\n", e.GetAddr(),
+					ThunkEntrySize, uint64(1)<<isec.P2Align, e.Sym.Name)
+			}
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"debug/elf"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"math"
+	"strings"
 )
 
 type ObjectFile struct {
@@ -18,6 +20,12 @@ type ObjectFile struct {
 	SymtabShndxSec []uint32
 
 	IsAlive       bool // active or not (inactive indicates in lib), which means finding symbols needed
+	IsDso         bool // shared object, only its dynamic symbols are read
+	Soname        string
+	AsNeeded      bool     // only DT_NEEDED if a symbol is used (--as-needed)
+	Versyms       []uint16 // version of each dynamic symbol of a shared object
+	VerNames      []string // versions a shared object defines, by index
+	SymVers       []string // foo@@VER or foo@VER defined by an object, by symbol index
 	InputSections []*InputSection
 	Symbols       []*Symbol
 	LocalSymbols  []*Symbol
@@ -25,6 +33,14 @@ type ObjectFile struct {
 	TotalSecs     uint32
 
 	MergeableSections []*MergeableSection
+
+	ComdatGroups  []*ComdatGroup
+	DiscardedSecs []bool // members of comdat groups that lost
+
+	EhFrameSection *InputSection
+	Cies           []*CieRecord
+	Fdes           []*FdeRecord
+	EhFrameSymbols []*Symbol // value is the offset inside .eh_frame until fixed
 }
 
 // fill in ElfEhdr, ElfSecHdrs, ShStrTab
@@ -35,14 +51,23 @@ func NewObjectFile(file *File, isAlive bool, ctx *Context) {
 		IsAlive:    isAlive,
 	}
 
-	if len(file.Content) < EhdrSize {
+	f.ParseHeaders()
+	f.ParseSymtabShndxSec() // if there exist the section
+	f.ParseSymTab(ctx)
+	f.ParseComdatGroups()
+	ctx.Args.ObjFiles = append(ctx.Args.ObjFiles, &f)
+}
+
+// fill in ElfEhdr, ElfSecHdrs, ShStrTab, shared by objects and shared objects
+func (f *ObjectFile) ParseHeaders() {
+	if len(f.File.Content) < EhdrSize {
 		utils.Fatal("file is smaller than Ehdr size")
 	}
-	MustHaveMagic(file.Content)
+	MustHaveMagic(f.File.Content)
 
-	utils.Read[Ehdr](file.Content, &f.ElfEhdr)
+	utils.Read[Ehdr](f.File.Content, &f.ElfEhdr)
 
-	secHdrContent := file.Content[f.ElfEhdr.ShOff:]
+	secHdrContent := f.File.Content[f.ElfEhdr.ShOff:]
 	shdr := Shdr{}
 	utils.Read[Shdr](secHdrContent, &shdr)
 	f.ElfSecHdrs = append(f.ElfSecHdrs, shdr)
@@ -66,9 +91,6 @@ func NewObjectFile(file *File, isAlive bool, ctx *Context) {
 		shStrndx = f.ElfSecHdrs[0].Link
 	}
 	f.ShStrTab = f.GetBytesFromIdx(shStrndx)
-
-	f.ParseSymTab(ctx)
-	ctx.Args.ObjFiles = append(ctx.Args.ObjFiles, &f)
 }
 
 func (f *ObjectFile) GetEhdr() *Ehdr {
@@ -110,6 +132,9 @@ func (f *ObjectFile) FillInElfSymsAndSymbols(ctx *Context, shdr *Shdr) {
 	f.ElfSyms = make([]Sym, nums)
 	f.Symbols = make([]*Symbol, nums)
 	f.TotalSyms = uint32(nums)
+	if !f.IsDso {
+		f.SymVers = make([]string, nums)
+	}
 	for i := 0; i < nums; i++ {
 		s := Sym{}
 		utils.Read[Sym](bs, &s)
@@ -119,18 +144,48 @@ func (f *ObjectFile) FillInElfSymsAndSymbols(ctx *Context, shdr *Shdr) {
 		if uint32(i) < f.FirstGlobal {
 			symbol := NewSymbol(f, name)
 			symbol.File = f
+			symbol.SetSymIdx(uint32(i))
 			f.Symbols[i] = symbol
-		} else {
-			f.Symbols[i] = ctx.GetSymbol(name)
-			if !s.IsUndef() {
-				(*f.Symbols[i]).File = f
+			if i > 0 {
+				f.LocalSymbols = append(f.LocalSymbols, symbol)
 			}
+		} else {
+			// the owner is decided later in ResolveSymbols
+			f.Symbols[i] = ctx.GetSymbol(f.getVersionedName(uint32(i), name))
 		}
 
 		bs = bs[SymSize:] // does not panic if idx reaches length
 	}
 }
 
+// the default version of a symbol is reached by its plain name, foo@@VER
+// defines foo, an old version (foo@VER) only by the versioned name
+func (f *ObjectFile) getVersionedName(idx uint32, name string) string {
+	esym := &f.ElfSyms[idx]
+	if f.IsDso {
+		if esym.IsUndef() || int(idx) >= len(f.Versyms) {
+			return name
+		}
+		ver := f.Versyms[idx] &^ VERSYM_HIDDEN
+		if f.Versyms[idx]&VERSYM_HIDDEN == 0 || ver <= VER_NDX_GLOBAL ||
+			int(ver) >= len(f.VerNames) {
+			return name
+		}
+		return name + "@" + f.VerNames[ver]
+	}
+
+	at := strings.IndexByte(name, '@')
+	if esym.IsUndef() || at < 0 {
+		return name
+	}
+	if strings.HasPrefix(name[at:], "@@") {
+		f.SymVers[idx] = name[at+2:]
+		return name[:at]
+	}
+	f.SymVers[idx] = name[at+1:]
+	return name
+}
+
 // fill in elfSyms (name is simply the offset)
 // find symbol table section header and
 // create symbol array
@@ -202,6 +257,15 @@ func (f *ObjectFile) ParseSymbols(ctx *Context) {
 		if i == 0 {
 			continue
 		}
+		// global symbols are only set up by the file owning them
+		if f.Symbols[i].File != f {
+			continue
+		}
+		// abs symbols' value is already the final address
+		if esym.IsAbs() {
+			f.Symbols[i].SetValue(esym.Val)
+			continue
+		}
 		if !esym.IsAbs() && !esym.IsUndef() && !esym.IsCommon() {
 			sym := f.Symbols[i]
 			shndx := esym.GetShndx(f.SymtabShndxSec, uint32(i))
@@ -213,6 +277,17 @@ func (f *ObjectFile) ParseSymbols(ctx *Context) {
 			if iSec.IsAlive && mSec == nil {
 				sym.SetInputSection(iSec)
 				sym.SetValue(esym.Val)
+			} else if f.IsSectionDiscarded(shndx) {
+				// section symbols are kept pointing to the dead section,
+				// so FDEs of the discarded copy are dropped
+				if esym.Type() != uint8(elf.STT_SECTION) {
+					sym.SetInputSection(f.GetComdatReplacement(ctx, shndx))
+					sym.SetValue(esym.Val)
+				}
+			} else if iSec == f.EhFrameSection {
+				sym.SetInputSection(nil)
+				sym.SetValue(esym.Val)
+				f.EhFrameSymbols = append(f.EhFrameSymbols, sym)
 			} else if !iSec.IsAlive && mSec != nil {
 				frag, fragOffset := mSec.GetFragment(esym.Val) // return offset within the fragment
 				if frag == nil {
@@ -226,9 +301,12 @@ func (f *ObjectFile) ParseSymbols(ctx *Context) {
 }
 
 func (f *ObjectFile) ParseFile(ctx *Context) {
-	f.ParseSymtabShndxSec() // if there exist the section
+	// nothing is copied from a shared object
+	if f.IsDso {
+		return
+	}
 	f.ParseInputSections(ctx)
-	f.SkipEhframeSections()
+	f.ParseEhFrame()              // split .eh_frame into CIEs and FDEs
 	f.ParseMergeableSections(ctx) // create mergeable section array, and store fragments into merged section in ctx
 	f.ParseSymbols(ctx)           // should be after parsing sections, set up sym arrays and global syms
 	// change the "mergeable input sections" into mergeable sections
@@ -249,12 +327,20 @@ func (f *ObjectFile) ParseInputSections(ctx *Context) {
 			break
 		default:
 			iName := ElfGetName(f.ShStrTab, hdr.Name)
-			iContent := f.GetBytesFromIdx(i)
+			// nobits sections (e.g. .bss) take no space in the file
+			var iContent []byte
+			if hdr.Type != uint32(elf.SHT_NOBITS) {
+				iContent = f.GetBytesFromIdx(i)
+			}
 			iSection := NewInputSection(f, iContent, i, &f.ElfSecHdrs[i], iName)
 			iSection.SetInputSectionSize(hdr.Size)
 			iSection.SetP2Align(hdr.AddrAlign)
 			oSection := iSection.GetInputSectionOutputSection(ctx)
 			iSection.SetInputSectionOutputSection(oSection)
+			// the kept copy of the comdat group is in another file
+			if f.IsSectionDiscarded(i) {
+				iSection.IsAlive = false
+			}
 			// the following line is different from how the tutorial did
 			// it uses an extra pass
 			// wrong!
@@ -278,6 +364,73 @@ func (f *ObjectFile) ParseInputSections(ctx *Context) {
 	}
 }
 
+// archive members are shown as libfoo.a(bar.o)
+func (f *ObjectFile) GetFileName() string {
+	if f.File.Parent != nil {
+		return f.File.Parent.Name + "(" + f.File.Name + ")"
+	}
+	return f.File.Name
+}
+
+// smaller is better, files not alive yet (in archives) are lazy
+// and are only chosen if no live file defines the symbol
+// shared objects rank like archives, so whichever comes first wins
+func getSymbolRank(file *ObjectFile, esym *Sym) uint32 {
+	if esym.IsUndef() {
+		return math.MaxUint32
+	}
+
+	rank := uint32(1)
+	if esym.IsCommon() {
+		rank = 3
+	} else if esym.Bind() == uint8(elf.STB_WEAK) {
+		rank = 2
+	}
+	if !file.IsAlive || file.IsDso {
+		rank += 3
+	}
+	return rank
+}
+
+// if ranks are equal, the first file wins
+func (f *ObjectFile) ResolveSymbols() {
+	for i := f.FirstGlobal; i < f.TotalSyms; i++ {
+		esym := &f.ElfSyms[i]
+		sym := f.Symbols[i]
+		if esym.IsUndef() || f.IsSymbolDiscarded(i) {
+			continue
+		}
+		if getSymbolRank(f, esym) < sym.GetRank() {
+			sym.File = f
+			sym.SetSymIdx(i)
+		}
+	}
+}
+
+func (f *ObjectFile) MergeVisibility() {
+	for i := f.FirstGlobal; i < f.TotalSyms; i++ {
+		if !f.IsSymbolDiscarded(i) {
+			f.Symbols[i].MergeVisibility(f.ElfSyms[i].Visibility())
+		}
+	}
+}
+
+// only strong definitions conflict with each other
+func (f *ObjectFile) CheckDuplicateSymbols() []string {
+	errs := make([]string, 0)
+	for i := f.FirstGlobal; i < f.TotalSyms; i++ {
+		esym := &f.ElfSyms[i]
+		sym := f.Symbols[i]
+		if sym.File == f || sym.File == nil || f.IsSymbolDiscarded(i) ||
+			getSymbolRank(f, esym) != 1 || sym.GetRank() != 1 {
+			continue
+		}
+		errs = append(errs, "multiple definition of `"+sym.Name+"': "+
+			sym.File.GetFileName()+" and "+f.GetFileName())
+	}
+	return errs
+}
+
 func (f *ObjectFile) MarkLiveObjects(ctx *Context, roots []*ObjectFile) []*ObjectFile {
 	for i := f.FirstGlobal; i < f.TotalSyms; i++ {
 		esym := f.ElfSyms[i]
@@ -287,7 +440,9 @@ func (f *ObjectFile) MarkLiveObjects(ctx *Context, roots []*ObjectFile) []*Objec
 		if sym.File == nil {
 			continue
 		}
-		if esym.IsUndef() && !sym.File.IsAlive {
+		// weak references don't pull files out from archives
+		if esym.IsUndef() && esym.Bind() != uint8(elf.STB_WEAK) &&
+			!sym.File.IsAlive {
 			sym.File.IsAlive = true
 			roots = append(roots, sym.File)
 		}
@@ -415,19 +570,39 @@ func (f *ObjectFile) ChangeMSecsSymbolsSection() {
 	}
 }
 
-func (o *ObjectFile) SkipEhframeSections() {
-	for _, isec := range o.InputSections {
-		if isec != nil && isec.IsAlive && isec.Name == ".eh_frame" {
-			isec.IsAlive = false
-		}
+// relocations against the section symbol of a mergeable section use the addend
+// as the offset inside the original section, so the fragment is found with it
+// returns the fragment and the offset inside it
+// S and A of a relocation, relocations against mergeable sections
+// point to the fragment instead, and ifuncs to their plt stub
+func (f *ObjectFile) GetRelSymbolAddrAndAddend(ctx *Context, rel *Rela) (uint64, uint64) {
+	if frag, fragOffset := f.GetFragmentOfRel(rel); frag != nil {
+		return frag.GetAddr(), fragOffset
+	}
+	sym := f.Symbols[rel.Sym]
+	if sym.Flags&NeedsPlt != 0 {
+		return sym.GetPltAddr(ctx), f.GetRelAddend(rel)
+	}
+	return sym.GetAddr(), f.GetRelAddend(rel)
+}
+
+func (f *ObjectFile) GetFragmentOfRel(rel *Rela) (*SectionFragment, uint64) {
+	esym := &f.ElfSyms[rel.Sym]
+	if esym.Type() != uint8(elf.STT_SECTION) || esym.IsAbs() || esym.IsUndef() {
+		return nil, 0
+	}
+	mSec := f.MergeableSections[esym.GetShndx(f.SymtabShndxSec, rel.Sym)]
+	if mSec == nil {
+		return nil, 0
 	}
+	return mSec.GetFragment(esym.Val + uint64(rel.Addend))
 }
 
-func (o *ObjectFile) ScanRelsFindGotSyms() {
+func (o *ObjectFile) ScanRelsFindGotSyms(ctx *Context) {
 	for _, isec := range o.InputSections {
 		if isec != nil && isec.IsAlive &&
 			isec.Shdr.Flags&uint64(elf.SHF_ALLOC) != 0 {
-			isec.ScanRelsFindGotSyms()
+			isec.ScanRelsFindGotSyms(ctx)
 		}
 	}
 }
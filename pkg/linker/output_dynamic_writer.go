@@ -0,0 +1,195 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"strings"
+)
+
+// .dynamic tells the dynamic loader where everything is, found through
+// PT_DYNAMIC, each entry is a tag and a value
+type OutputDynamicWriter struct {
+	OutputWriter
+	Needed []uint32 // sonames in .dynstr
+	Rpath  uint32
+	Soname uint32
+}
+
+func NewOutputDynamicWriter() *OutputDynamicWriter {
+	d := &OutputDynamicWriter{OutputWriter: *NewOutputWriter()}
+	d.Name = ".dynamic"
+	d.Shdr.Type = uint32(elf.SHT_DYNAMIC)
+	d.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_WRITE)
+	d.Shdr.EntSize = uint64(DynSize)
+	d.Shdr.AddrAlign = 8
+	return d
+}
+
+// strings are added to .dynstr before the symbol names
+func (d *OutputDynamicWriter) AddStrings(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		if file.IsDso {
+			d.Needed = append(d.Needed, ctx.OutputDynstrWriter.AddString(file.Soname))
+		}
+	}
+	if len(ctx.Args.Rpaths) > 0 {
+		d.Rpath = ctx.OutputDynstrWriter.AddString(strings.Join(ctx.Args.Rpaths, ":"))
+	}
+	if ctx.Args.Soname != "" {
+		d.Soname = ctx.OutputDynstrWriter.AddString(ctx.Args.Soname)
+	}
+}
+
+// the soname of a shared object in .dynstr
+func (d *OutputDynamicWriter) GetNeeded(ctx *Context, file *ObjectFile) uint32 {
+	idx := 0
+	for _, other := range ctx.Args.ObjFiles {
+		if other == file {
+			return d.Needed[idx]
+		}
+		if other.IsDso {
+			idx++
+		}
+	}
+	return 0
+}
+
+// a shared object using initial exec tls needs its tls block allocated
+// at startup, it cannot be loaded by dlopen later
+func hasStaticTls(ctx *Context) bool {
+	if !ctx.Args.Shared {
+		return false
+	}
+	for _, e := range ctx.OutputGotSectionWriter.Entries {
+		if e.Kind == GotEntryTp {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *OutputDynamicWriter) getEntries(ctx *Context) []Dyn {
+	entries := make([]Dyn, 0)
+	define := func(tag elf.DynTag, val uint64) {
+		entries = append(entries, Dyn{Tag: int64(tag), Val: val})
+	}
+
+	for _, needed := range d.Needed {
+		define(elf.DT_NEEDED, uint64(needed))
+	}
+	if ctx.Args.Soname != "" {
+		define(elf.DT_SONAME, uint64(d.Soname))
+	}
+	if len(ctx.Args.Rpaths) > 0 {
+		if ctx.Args.EnableNewDtags {
+			define(elf.DT_RUNPATH, uint64(d.Rpath))
+		} else {
+			define(elf.DT_RPATH, uint64(d.Rpath))
+		}
+	}
+
+	for _, name := range []string{"_init", "_fini"} {
+		sym, ok := ctx.SymbolMap[name]
+		if !ok || sym.File == nil || sym.IsImported() {
+			continue
+		}
+		if name == "_init" {
+			define(elf.DT_INIT, sym.GetAddr())
+		} else {
+			define(elf.DT_FINI, sym.GetAddr())
+		}
+	}
+	arrays := []struct {
+		name       string
+		addr, size elf.DynTag
+	}{
+		{".preinit_array", elf.DT_PREINIT_ARRAY, elf.DT_PREINIT_ARRAYSZ},
+		{".init_array", elf.DT_INIT_ARRAY, elf.DT_INIT_ARRAYSZ},
+		{".fini_array", elf.DT_FINI_ARRAY, elf.DT_FINI_ARRAYSZ},
+	}
+	for _, array := range arrays {
+		if o := getOutputWriterByName(ctx, array.name); o != nil {
+			define(array.addr, o.GetShdr().Addr)
+			define(array.size, o.GetShdr().Size)
+		}
+	}
+
+	if ctx.OutputHashWriter != nil {
+		define(elf.DT_HASH, ctx.OutputHashWriter.Shdr.Addr)
+	}
+	if ctx.OutputGnuHashWriter != nil {
+		define(elf.DT_GNU_HASH, ctx.OutputGnuHashWriter.Shdr.Addr)
+	}
+	define(elf.DT_STRTAB, ctx.OutputDynstrWriter.Shdr.Addr)
+	define(elf.DT_STRSZ, ctx.OutputDynstrWriter.Shdr.Size)
+	define(elf.DT_SYMTAB, ctx.OutputDynsymWriter.Shdr.Addr)
+	define(elf.DT_SYMENT, uint64(SymSize))
+
+	if ctx.OutputVersymWriter != nil {
+		define(elf.DT_VERSYM, ctx.OutputVersymWriter.Shdr.Addr)
+	}
+	if verdef := ctx.OutputVerdefWriter; verdef != nil {
+		define(elf.DT_VERDEF, verdef.Shdr.Addr)
+		define(elf.DT_VERDEFNUM, uint64(verdef.Shdr.Info))
+	}
+	if verneed := ctx.OutputVerneedWriter; verneed != nil {
+		define(elf.DT_VERNEED, verneed.Shdr.Addr)
+		define(elf.DT_VERNEEDNUM, uint64(verneed.Shdr.Info))
+	}
+
+	if rela := ctx.OutputRelaDynWriter; rela.Shdr.Size > 0 {
+		define(elf.DT_RELA, rela.Shdr.Addr)
+		define(elf.DT_RELASZ, rela.Shdr.Size)
+		define(elf.DT_RELAENT, uint64(RelaSize))
+	}
+	if relr := ctx.OutputRelrDynWriter; relr != nil && relr.Shdr.Size > 0 {
+		define(DT_RELR, relr.Shdr.Addr)
+		define(DT_RELRSZ, relr.Shdr.Size)
+		define(DT_RELRENT, 8)
+	}
+	if len(ctx.OutputPltWriter.Syms) > 0 {
+		define(elf.DT_PLTGOT, ctx.OutputGotPltWriter.Shdr.Addr)
+		define(elf.DT_JMPREL, ctx.OutputRelaPltWriter.Shdr.Addr)
+		define(elf.DT_PLTRELSZ, ctx.OutputRelaPltWriter.Shdr.Size)
+		define(elf.DT_PLTREL, uint64(elf.DT_RELA))
+	}
+
+	// -z now, every symbol is bound at startup instead of the first call
+	flags := uint64(0)
+	flags1 := uint64(0)
+	if hasStaticTls(ctx) {
+		flags |= uint64(elf.DF_STATIC_TLS)
+	}
+	if ctx.Args.ZNow {
+		flags |= uint64(elf.DF_BIND_NOW)
+		flags1 |= uint64(elf.DF_1_NOW)
+	}
+	if ctx.Args.Pie {
+		flags1 |= uint64(elf.DF_1_PIE)
+	}
+	if flags != 0 {
+		define(elf.DT_FLAGS, flags)
+	}
+	if flags1 != 0 {
+		define(elf.DT_FLAGS_1, flags1)
+	}
+
+	// filled in by the dynamic loader for debuggers
+	if !ctx.Args.Shared {
+		define(elf.DT_DEBUG, 0)
+	}
+	define(elf.DT_NULL, 0)
+	return entries
+}
+
+func (d *OutputDynamicWriter) UpdateSize(ctx *Context) {
+	d.Shdr.Size = uint64(len(d.getEntries(ctx)) * DynSize)
+	d.Shdr.Link = uint32(ctx.OutputDynstrWriter.Shndx)
+}
+
+func (d *OutputDynamicWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[d.Shdr.Offset:]
+	for idx, dyn := range d.getEntries(ctx) {
+		utils.Write[Dyn](base[idx*DynSize:], dyn)
+	}
+}
@@ -0,0 +1,110 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .dynsym has the symbols the dynamic loader looks up, functions and data
+// of shared objects used by the output, and symbols of the output used by
+// shared objects
+// imported symbols come first, the others are looked up through the hash
+// tables and sorted for .gnu.hash
+type OutputDynsymWriter struct {
+	OutputWriter
+	Symbols    []*Symbol
+	StrOffs    []uint32
+	WeakUndefs map[*Symbol]bool // undefined in a shared object, only weak references
+}
+
+func NewOutputDynsymWriter() *OutputDynsymWriter {
+	d := &OutputDynsymWriter{OutputWriter: *NewOutputWriter()}
+	d.Name = ".dynsym"
+	d.Shdr.Type = uint32(elf.SHT_DYNSYM)
+	d.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	d.Shdr.EntSize = uint64(SymSize)
+	d.Shdr.AddrAlign = 8
+	d.Symbols = []*Symbol{nil} // first symbol is empty
+	d.StrOffs = []uint32{0}
+	d.WeakUndefs = make(map[*Symbol]bool)
+	return d
+}
+
+// symbols defined in the output, including the ones of shared objects
+// that the output provides (copies and canonical plt stubs)
+func isDynsymHashed(sym *Symbol) bool {
+	return sym.File != nil &&
+		(!sym.IsImported() || sym.Flags&(NeedsCopyrel|NeedsCanonicalPlt) != 0)
+}
+
+func (d *OutputDynsymWriter) AddSymbol(ctx *Context, sym *Symbol) {
+	sym.DynsymIdx = uint32(len(d.Symbols))
+	d.Symbols = append(d.Symbols, sym)
+	d.StrOffs = append(d.StrOffs, ctx.OutputDynstrWriter.AddString(sym.GetDynName()))
+}
+
+// the index of the first hashed symbol
+func (d *OutputDynsymWriter) GetFirstHashed() int {
+	for idx := 1; idx < len(d.Symbols); idx++ {
+		if isDynsymHashed(d.Symbols[idx]) {
+			return idx
+		}
+	}
+	return len(d.Symbols)
+}
+
+// only the null symbol is local
+func (d *OutputDynsymWriter) UpdateSize(ctx *Context) {
+	d.Shdr.Size = uint64(len(d.Symbols) * SymSize)
+	d.Shdr.Link = uint32(ctx.OutputDynstrWriter.Shndx)
+	d.Shdr.Info = 1
+}
+
+func (d *OutputDynsymWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[d.Shdr.Offset:]
+	utils.Write[Sym](base, Sym{})
+	for idx := 1; idx < len(d.Symbols); idx++ {
+		sym := d.Symbols[idx]
+		if sym.File == nil {
+			// undefined in a shared object
+			bind := elf.STB_GLOBAL
+			if d.WeakUndefs[sym] {
+				bind = elf.STB_WEAK
+			}
+			utils.Write[Sym](base[idx*SymSize:], Sym{
+				Name: d.StrOffs[idx],
+				Info: uint8(bind) << 4,
+			})
+			continue
+		}
+
+		esym := sym.GetElfSym()
+		out := Sym{
+			Name:  d.StrOffs[idx],
+			Info:  esym.Info,
+			Other: esym.Other,
+			Size:  esym.Size,
+		}
+		if !sym.IsImported() {
+			out.Other = esym.Other&^3 | sym.Visibility
+		}
+
+		switch {
+		case sym.IsImported() && sym.Flags&NeedsCopyrel == 0:
+			// undefined, a canonical plt stub gives the address though
+			out.Info = uint8(elf.STB_GLOBAL)<<4 | esym.Type()
+			out.Shndx = uint16(elf.SHN_UNDEF)
+			if sym.Flags&NeedsCanonicalPlt != 0 {
+				out.Val = sym.GetPltAddr(ctx)
+			}
+		default:
+			out.Shndx = getOutputShndx(sym)
+			out.Val = sym.GetAddr()
+			// tls symbols hold the offset inside the tls segment
+			if esym.Type() == uint8(elf.STT_TLS) {
+				out.Val -= ctx.TLSSegmentAddr
+			}
+		}
+		utils.Write[Sym](base[idx*SymSize:], out)
+	}
+}
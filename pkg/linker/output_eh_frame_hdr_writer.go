@@ -0,0 +1,75 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"sort"
+)
+
+// pointer encodings used in .eh_frame_hdr
+const (
+	DW_EH_PE_udata4  uint8 = 0x03
+	DW_EH_PE_sdata4  uint8 = 0x0b
+	DW_EH_PE_pcrel   uint8 = 0x10
+	DW_EH_PE_datarel uint8 = 0x30
+)
+
+const EhFrameHdrSize = 12
+
+// .eh_frame_hdr has a sorted table of (function address, FDE address),
+// so the unwinder can binary search the FDE of a pc
+// it is found through the PT_GNU_EH_FRAME segment
+type OutputEhFrameHdrWriter struct {
+	OutputWriter
+}
+
+func NewOutputEhFrameHdrWriter() *OutputEhFrameHdrWriter {
+	e := &OutputEhFrameHdrWriter{OutputWriter: *NewOutputWriter()}
+	e.Name = ".eh_frame_hdr"
+	e.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	e.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	e.Shdr.AddrAlign = 4
+	return e
+}
+
+// size depends on the number of FDEs, so .eh_frame has to be updated first
+func (e *OutputEhFrameHdrWriter) UpdateSize(ctx *Context) {
+	ctx.OutputEhFrameWriter.UpdateSize(ctx)
+	e.Shdr.Size = uint64(EhFrameHdrSize + len(ctx.OutputEhFrameWriter.Fdes)*8)
+}
+
+func (e *OutputEhFrameHdrWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[e.Shdr.Offset:]
+	ehFrame := ctx.OutputEhFrameWriter
+	hdrAddr := e.Shdr.Addr
+
+	// version, eh_frame_ptr encoding, fde_count encoding, table encoding
+	base[0] = 1
+	base[1] = DW_EH_PE_pcrel | DW_EH_PE_sdata4
+	base[2] = DW_EH_PE_udata4
+	base[3] = DW_EH_PE_datarel | DW_EH_PE_sdata4
+	utils.Write[uint32](base[4:], uint32(ehFrame.Shdr.Addr-(hdrAddr+4)))
+	utils.Write[uint32](base[8:], uint32(len(ehFrame.Fdes)))
+
+	type entry struct {
+		InitialLoc uint64
+		FdeAddr    uint64
+	}
+	entries := make([]entry, 0, len(ehFrame.Fdes))
+	for _, fde := range ehFrame.Fdes {
+		entries = append(entries, entry{
+			InitialLoc: fde.GetInitialLocation(),
+			FdeAddr:    ehFrame.Shdr.Addr + fde.OutputOffset,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].InitialLoc < entries[j].InitialLoc
+	})
+
+	// both relative to the start of .eh_frame_hdr
+	table := base[EhFrameHdrSize:]
+	for idx, ent := range entries {
+		utils.Write[uint32](table[idx*8:], uint32(ent.InitialLoc-hdrAddr))
+		utils.Write[uint32](table[idx*8+4:], uint32(ent.FdeAddr-hdrAddr))
+	}
+}
@@ -0,0 +1,127 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// merged .eh_frame, all leader CIEs come first and then the live FDEs,
+// so that an FDE always points backward to its CIE
+type OutputEhFrameWriter struct {
+	OutputWriter
+	Cies []*CieRecord
+	Fdes []*FdeRecord
+}
+
+func NewOutputEhFrameWriter() *OutputEhFrameWriter {
+	e := &OutputEhFrameWriter{OutputWriter: *NewOutputWriter()}
+	e.Name = ".eh_frame"
+	e.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	e.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	e.Shdr.AddrAlign = 8
+	return e
+}
+
+// should be called after sections are discarded (dead FDEs are dropped here)
+func (e *OutputEhFrameWriter) UpdateSize(ctx *Context) {
+	e.Cies = make([]*CieRecord, 0)
+	e.Fdes = make([]*FdeRecord, 0)
+
+	offset := uint64(0)
+	for _, file := range ctx.Args.ObjFiles {
+		for _, cie := range file.Cies {
+			cie.Leader = nil
+			for _, leader := range e.Cies {
+				if cie.Equals(leader) {
+					cie.Leader = leader
+					break
+				}
+			}
+			if cie.Leader != nil {
+				continue
+			}
+			cie.Leader = cie
+			cie.OutputOffset = offset
+			offset += uint64(len(cie.Contents))
+			e.Cies = append(e.Cies, cie)
+		}
+	}
+
+	for _, file := range ctx.Args.ObjFiles {
+		for _, fde := range file.Fdes {
+			fde.UpdateIsAlive()
+			if !fde.IsAlive {
+				continue
+			}
+			fde.OutputOffset = offset
+			offset += uint64(len(fde.Contents))
+			e.Fdes = append(e.Fdes, fde)
+		}
+	}
+
+	// null terminator
+	e.Shdr.Size = offset + 4
+}
+
+func (e *OutputEhFrameWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[e.Shdr.Offset:]
+
+	apply := func(file *ObjectFile, loc []byte, rels []Rela, inputOffset, outputOffset uint64) {
+		for _, rel := range rels {
+			offset := rel.Offset - inputOffset
+			S, A := file.GetRelSymbolAddrAndAddend(ctx, &rel)
+			P := e.Shdr.Addr + outputOffset + offset
+			// only data relocations show up in .eh_frame
+			if !applyDataReloc(loc[offset:], rel.Type, S, A, P) {
+				utils.Fatal(file.GetFileName() + ": unsupported relocation in .eh_frame: " +
+					relTypeName(rel.Type))
+			}
+		}
+	}
+
+	for _, cie := range e.Cies {
+		loc := base[cie.OutputOffset:]
+		copy(loc, cie.Contents)
+		apply(cie.ObjFile, loc, cie.Rels, cie.Offset, cie.OutputOffset)
+	}
+
+	for _, fde := range e.Fdes {
+		loc := base[fde.OutputOffset:]
+		copy(loc, fde.Contents)
+		// CIE pointer is the distance from this field back to the CIE
+		cieOffset := fde.Cie.Leader.OutputOffset
+		utils.Write[uint32](loc[4:], uint32(fde.OutputOffset+4-cieOffset))
+		apply(fde.ObjFile, loc, fde.Rels, fde.Offset, fde.OutputOffset)
+	}
+
+	utils.Write[uint32](base[e.Shdr.Size-4:], 0)
+}
+
+// symbols defined inside .eh_frame (e.g. __EH_FRAME_BEGIN__ in crtbegin.o)
+// point to the first record at or after them, or the terminator if none
+func (e *OutputEhFrameWriter) GetSymbolAddr(ctx *Context, file *ObjectFile, offset uint64) uint64 {
+	fileIdx := make(map[*ObjectFile]int)
+	for idx, f := range ctx.Args.ObjFiles {
+		fileIdx[f] = idx
+	}
+
+	after := func(recFile *ObjectFile, recOffset uint64) bool {
+		if recFile == file {
+			return recOffset >= offset
+		}
+		return fileIdx[recFile] > fileIdx[file]
+	}
+
+	best := e.Shdr.Size - 4
+	for _, cie := range e.Cies {
+		if after(cie.ObjFile, cie.Offset) {
+			best = min(best, cie.OutputOffset)
+		}
+	}
+	for _, fde := range e.Fdes {
+		if after(fde.ObjFile, fde.Offset) {
+			best = min(best, fde.OutputOffset)
+		}
+	}
+	return e.Shdr.Addr + best
+}
@@ -5,6 +5,7 @@ import (
 	"debug/elf"
 	"encoding/binary"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"strconv"
 )
 
 // should setup size, offset before using
@@ -25,7 +26,22 @@ func NewOutputEhdrWriter() *OutputEhdrWriter {
 	}
 }
 
+// -e could be either a symbol or an address
+// if neither works, fall back to the start of .text like gnu ld does
 func getEntryAddress(ctx *Context) uint64 {
+	if sym, ok := ctx.SymbolMap[ctx.Args.Entry]; ok &&
+		sym.File != nil && sym.File.IsAlive {
+		return sym.GetAddr()
+	}
+	if addr, err := strconv.ParseUint(ctx.Args.Entry, 0, 64); err == nil {
+		return addr
+	}
+
+	// a shared object doesn't need one
+	if ctx.Args.Shared {
+		return 0
+	}
+	utils.Warn("cannot find entry symbol " + ctx.Args.Entry + ", defaulting to start of .text")
 	for _, osec := range ctx.OutputSections {
 		if osec.Name == ".text" {
 			return osec.Shdr.Addr
@@ -35,13 +51,20 @@ func getEntryAddress(ctx *Context) uint64 {
 }
 
 // check if there are compressed instructions or not (32 -> 16)
+// shared objects are not part of the output, their flags don't count
 func getFlags(ctx *Context) uint32 {
 	utils.Assert(len(ctx.Args.ObjFiles) > 0)
-	flags := ctx.Args.ObjFiles[0].GetEhdr().Flags
-	for _, obj := range ctx.Args.ObjFiles[1:] {
-		if obj.GetEhdr().Flags&EF_RISCV_RVC != 0 {
+	flags := uint32(0)
+	first := true
+	for _, obj := range ctx.Args.ObjFiles {
+		if obj.IsDso || obj == ctx.InternalObj {
+			continue
+		}
+		if first {
+			flags = obj.GetEhdr().Flags
+			first = false
+		} else if obj.GetEhdr().Flags&EF_RISCV_RVC != 0 {
 			flags |= EF_RISCV_RVC
-			break
 		}
 	}
 
@@ -58,16 +81,20 @@ func (o *OutputEhdrWriter) CopyBuf(ctx *Context) {
 	ehdr.Ident[elf.EI_ABIVERSION] = 0
 	ehdr.Flags = getFlags(ctx)
 	ehdr.Type = uint16(elf.ET_EXEC)
+	if ctx.IsPic() {
+		ehdr.Type = uint16(elf.ET_DYN)
+	}
 	ehdr.Machine = uint16(elf.EM_RISCV)
 	ehdr.Version = uint32(elf.EV_CURRENT)
 	ehdr.Entry = getEntryAddress(ctx)
 	ehdr.EhSize = uint16(EhdrSize)
 	ehdr.PhEntSize = uint16(PhdrSize)
-	//ehdr.ShOff = ctx.OutputShdrsWriter.Shdr.Offset
+	ehdr.ShOff = ctx.OutputShdrsWriter.Shdr.Offset
 	ehdr.ShEntSize = uint16(ShdrSize)
 	ehdr.PhOff = ctx.OutputPhdrsWriter.Shdr.Offset
 	ehdr.PhNum = uint16(ctx.OutputPhdrsWriter.Shdr.Size / uint64(PhdrSize))
-	//ehdr.ShNum = uint16(ctx.OutputShdrsWriter.Shdr.Size / uint64(ShdrSize))
+	ehdr.ShNum = uint16(ctx.OutputShdrsWriter.Shdr.Size / uint64(ShdrSize))
+	ehdr.ShStrndx = uint16(ctx.OutputShStrtabWriter.Shndx)
 	buf := bytes.Buffer{}
 	err := binary.Write(&buf, binary.LittleEndian, ehdr)
 	utils.MustNo(err)
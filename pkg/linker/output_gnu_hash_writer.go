@@ -0,0 +1,90 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+const (
+	GnuHashLoadFactor = 8  // symbols per bucket
+	GnuHashBloomBits  = 12 // bloom filter bits per symbol
+	GnuHashBloomShift = 26
+)
+
+// .gnu.hash only covers the hashed symbols at the end of .dynsym,
+// which are sorted by bucket
+// nbuckets, symoffset, bloom size, bloom shift, bloom filter (8 bytes each),
+// buckets with the first symbol of each, and a chain value per symbol,
+// the hash with the lowest bit set at the end of a bucket
+type OutputGnuHashWriter struct {
+	OutputWriter
+}
+
+func NewOutputGnuHashWriter() *OutputGnuHashWriter {
+	h := &OutputGnuHashWriter{OutputWriter: *NewOutputWriter()}
+	h.Name = ".gnu.hash"
+	h.Shdr.Type = uint32(elf.SHT_GNU_HASH)
+	h.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	h.Shdr.AddrAlign = 8
+	return h
+}
+
+func gnuHash(name string) uint32 {
+	h := uint32(5381)
+	for _, c := range []byte(name) {
+		h = h*33 + uint32(c)
+	}
+	return h
+}
+
+func getGnuHashBuckets(numHashed int) uint32 {
+	return uint32(numHashed/GnuHashLoadFactor + 1)
+}
+
+func getGnuHashBloomSize(numHashed int) uint32 {
+	return uint32(utils.BitCeil(uint64(max(numHashed*GnuHashBloomBits/64, 1))))
+}
+
+func (h *OutputGnuHashWriter) UpdateSize(ctx *Context) {
+	dynsym := ctx.OutputDynsymWriter
+	numHashed := len(dynsym.Symbols) - dynsym.GetFirstHashed()
+	h.Shdr.Size = uint64(16 + getGnuHashBloomSize(numHashed)*8 +
+		getGnuHashBuckets(numHashed)*4 + uint32(numHashed)*4)
+	h.Shdr.Link = uint32(dynsym.Shndx)
+}
+
+func (h *OutputGnuHashWriter) CopyBuf(ctx *Context) {
+	dynsym := ctx.OutputDynsymWriter
+	first := dynsym.GetFirstHashed()
+	syms := dynsym.Symbols[first:]
+	nbuckets := getGnuHashBuckets(len(syms))
+	bloomSize := getGnuHashBloomSize(len(syms))
+
+	base := ctx.Buf[h.Shdr.Offset:]
+	utils.Write[uint32](base, nbuckets)
+	utils.Write[uint32](base[4:], uint32(first))
+	utils.Write[uint32](base[8:], bloomSize)
+	utils.Write[uint32](base[12:], GnuHashBloomShift)
+	bloom := base[16:]
+	buckets := bloom[bloomSize*8:]
+	chains := buckets[nbuckets*4:]
+
+	for idx, sym := range syms {
+		hash := gnuHash(sym.GetDynName())
+		word := bloom[(hash/64)%bloomSize*8:]
+		bits := uint64(1)<<(hash%64) | uint64(1)<<((hash>>GnuHashBloomShift)%64)
+		utils.Write[uint64](word, utils.ReadWithReturn[uint64](word)|bits)
+
+		b := hash % nbuckets
+		if utils.ReadWithReturn[uint32](buckets[b*4:]) == 0 {
+			utils.Write[uint32](buckets[b*4:], uint32(first+idx))
+		}
+		last := idx == len(syms)-1 || gnuHash(syms[idx+1].GetDynName())%nbuckets != b
+		if last {
+			hash |= 1
+		} else {
+			hash &^= 1
+		}
+		utils.Write[uint32](chains[idx*4:], hash)
+	}
+}
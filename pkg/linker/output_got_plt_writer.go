@@ -0,0 +1,36 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// the first two slots are filled by the dynamic loader with the
+// resolver and the link map
+const GotPltHdrEntries = 2
+
+// .got.plt has a slot for each .plt stub, pointing to the .plt header
+// until the dynamic loader binds the function
+type OutputGotPltWriter struct {
+	OutputWriter
+}
+
+func NewOutputGotPltWriter() *OutputGotPltWriter {
+	g := &OutputGotPltWriter{OutputWriter: *NewOutputWriter()}
+	g.Name = ".got.plt"
+	g.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	g.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_WRITE)
+	g.Shdr.AddrAlign = 8
+	return g
+}
+
+func (g *OutputGotPltWriter) UpdateSize(ctx *Context) {
+	g.Shdr.Size = uint64((GotPltHdrEntries + len(ctx.OutputPltWriter.Syms)) * 8)
+}
+
+func (g *OutputGotPltWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[g.Shdr.Offset:]
+	for _, sym := range ctx.OutputPltWriter.Syms {
+		utils.Write[uint64](base[(GotPltHdrEntries+sym.PltIdx)*8:], ctx.OutputPltWriter.Shdr.Addr)
+	}
+}
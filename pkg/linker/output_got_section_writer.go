@@ -5,9 +5,29 @@ import (
 	"github.com/hcyang1106/simple-linker/pkg/utils"
 )
 
+type GotEntryKind uint8
+
+const (
+	GotEntryAddr       GotEntryKind = iota // address of the symbol
+	GotEntryTp                             // offset of the symbol from tp
+	GotEntryTlsModule                      // module id, always 1 in an executable
+	GotEntryDtpOff                         // offset of the symbol from the dtv pointer
+	GotEntryIfunc                          // resolved by R_RISCV_IRELATIVE at startup
+	GotEntryTlsDesc                        // resolver of a tls descriptor, set by R_RISCV_TLSDESC
+	GotEntryTlsDescArg                     // argument of the resolver, set with it
+)
+
+// the dtv pointer of a module points 0x800 past its tls block on RISC-V
+const TlsDtvOffset = 0x800
+
+type GotEntry struct {
+	Sym  *Symbol
+	Kind GotEntryKind
+}
+
 type OutputGotSectionWriter struct {
 	OutputWriter
-	GotTLSSyms []*Symbol
+	Entries []GotEntry
 }
 
 func NewOutputGotSectionWriter() *OutputGotSectionWriter {
@@ -15,18 +35,66 @@ func NewOutputGotSectionWriter() *OutputGotSectionWriter {
 	g.Name = ".got"
 	g.Shdr.Type = uint32(elf.SHT_PROGBITS)
 	g.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_WRITE)
+	g.Shdr.AddrAlign = 8
 	return g
 }
 
-func (g *OutputGotSectionWriter) AddGotTLSSym(sym *Symbol) {
-	sym.GotEntryIdx = uint32(len(g.GotTLSSyms))
-	g.GotTLSSyms = append(g.GotTLSSyms, sym)
+func (g *OutputGotSectionWriter) addEntry(sym *Symbol, kind GotEntryKind) uint32 {
+	g.Entries = append(g.Entries, GotEntry{Sym: sym, Kind: kind})
 	g.Shdr.Size += 8
+	return uint32(len(g.Entries) - 1)
+}
+
+func (g *OutputGotSectionWriter) AddGotSym(sym *Symbol) {
+	sym.GotIdx = g.addEntry(sym, GotEntryAddr)
+}
+
+func (g *OutputGotSectionWriter) AddGotTpSym(sym *Symbol) {
+	sym.GotTpIdx = g.addEntry(sym, GotEntryTp)
+}
+
+// general dynamic, a pair of entries for __tls_get_addr
+func (g *OutputGotSectionWriter) AddTlsGdSym(sym *Symbol) {
+	sym.TlsGdIdx = g.addEntry(sym, GotEntryTlsModule)
+	g.addEntry(sym, GotEntryDtpOff)
+}
+
+// the tls descriptor of a shared object, the dynamic loader sets both
+// entries and the code calls the resolver with the address of the pair
+func (g *OutputGotSectionWriter) AddTlsDescSym(sym *Symbol) {
+	sym.TlsDescIdx = g.addEntry(sym, GotEntryTlsDesc)
+	g.addEntry(sym, GotEntryTlsDescArg)
+}
+
+// the plt stub of the ifunc jumps through this slot
+func (g *OutputGotSectionWriter) AddGotPltSym(sym *Symbol) {
+	sym.GotPltIdx = g.addEntry(sym, GotEntryIfunc)
 }
 
 func (g *OutputGotSectionWriter) CopyBuf(ctx *Context) {
 	base := ctx.Buf[g.Shdr.Offset:]
-	for idx, sym := range g.GotTLSSyms {
-		utils.Write[uint64](base[idx*8:], sym.GetAddr() - ctx.TLSSegmentAddr) // stores the offset from tp!
+	for idx, e := range g.Entries {
+		// filled in by the dynamic loader (.rela.dyn)
+		if e.Sym.IsPreemptible(ctx) {
+			continue
+		}
+		switch e.Kind {
+		case GotEntryAddr:
+			// an ifunc is known by the address of its plt stub
+			if e.Sym.Flags&NeedsPlt != 0 {
+				utils.Write[uint64](base[idx*8:], e.Sym.GetPltAddr(ctx))
+			} else {
+				utils.Write[uint64](base[idx*8:], e.Sym.GetAddr())
+			}
+		case GotEntryTp:
+			utils.Write[uint64](base[idx*8:], e.Sym.GetAddr()-ctx.TLSSegmentAddr) // stores the offset from tp!
+		case GotEntryTlsModule:
+			utils.Write[uint64](base[idx*8:], 1)
+		case GotEntryDtpOff:
+			utils.Write[uint64](base[idx*8:], e.Sym.GetAddr()-ctx.TLSSegmentAddr-TlsDtvOffset)
+		case GotEntryIfunc:
+			// the resolver, overwritten with what it returns
+			utils.Write[uint64](base[idx*8:], e.Sym.GetAddr())
+		}
 	}
 }
@@ -0,0 +1,58 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .hash is the sysv hash table of .dynsym
+// nbucket, nchain, buckets and chains, all 4 bytes
+// a bucket has the first symbol with the hash, chains link the others
+type OutputHashWriter struct {
+	OutputWriter
+}
+
+func NewOutputHashWriter() *OutputHashWriter {
+	h := &OutputHashWriter{OutputWriter: *NewOutputWriter()}
+	h.Name = ".hash"
+	h.Shdr.Type = uint32(elf.SHT_HASH)
+	h.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	h.Shdr.EntSize = 4
+	h.Shdr.AddrAlign = 8
+	return h
+}
+
+func elfHash(name string) uint32 {
+	h := uint32(0)
+	for _, c := range []byte(name) {
+		h = h<<4 + uint32(c)
+		g := h & 0xf0000000
+		if g != 0 {
+			h ^= g >> 24
+		}
+		h &^= g
+	}
+	return h
+}
+
+// one bucket per symbol
+func (h *OutputHashWriter) UpdateSize(ctx *Context) {
+	n := len(ctx.OutputDynsymWriter.Symbols)
+	h.Shdr.Size = uint64(2+n+n) * 4
+	h.Shdr.Link = uint32(ctx.OutputDynsymWriter.Shndx)
+}
+
+func (h *OutputHashWriter) CopyBuf(ctx *Context) {
+	syms := ctx.OutputDynsymWriter.Symbols
+	n := uint32(len(syms))
+	base := ctx.Buf[h.Shdr.Offset:]
+	utils.Write[uint32](base, n)
+	utils.Write[uint32](base[4:], n)
+	buckets := base[8:]
+	chains := base[8+n*4:]
+	for idx := uint32(1); idx < n; idx++ {
+		b := elfHash(syms[idx].GetDynName()) % n
+		utils.Write[uint32](chains[idx*4:], utils.ReadWithReturn[uint32](buckets[b*4:]))
+		utils.Write[uint32](buckets[b*4:], idx)
+	}
+}
@@ -0,0 +1,35 @@
+package linker
+
+import "debug/elf"
+
+const DefaultDynamicLinker = "/lib/ld-linux-riscv64-lp64d.so.1"
+
+// .interp holds the path of the dynamic loader, found through PT_INTERP
+type OutputInterpWriter struct {
+	OutputWriter
+}
+
+func NewOutputInterpWriter() *OutputInterpWriter {
+	i := &OutputInterpWriter{OutputWriter: *NewOutputWriter()}
+	i.Name = ".interp"
+	i.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	i.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	return i
+}
+
+func getDynamicLinker(ctx *Context) string {
+	if ctx.Args.DynamicLinker != "" {
+		return ctx.Args.DynamicLinker
+	}
+	return DefaultDynamicLinker
+}
+
+func (i *OutputInterpWriter) UpdateSize(ctx *Context) {
+	i.Shdr.Size = uint64(len(getDynamicLinker(ctx)) + 1)
+}
+
+func (i *OutputInterpWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[i.Shdr.Offset:]
+	copy(base, getDynamicLinker(ctx))
+	base[len(getDynamicLinker(ctx))] = 0
+}
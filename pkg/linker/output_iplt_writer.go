@@ -0,0 +1,45 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// auipc t3, hi; ld t3, lo(t3); jalr t1, t3; nop
+const IpltEntrySize = 16
+
+// .iplt has a stub for each ifunc, calls and address references go to
+// the stub, which jumps to what the resolver returned at startup
+type OutputIpltWriter struct {
+	OutputWriter
+	Syms []*Symbol
+}
+
+func NewOutputIpltWriter() *OutputIpltWriter {
+	p := &OutputIpltWriter{OutputWriter: *NewOutputWriter()}
+	p.Name = ".iplt"
+	p.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	p.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR)
+	p.Shdr.AddrAlign = 16
+	return p
+}
+
+func (p *OutputIpltWriter) AddSym(sym *Symbol) {
+	sym.PltIdx = uint32(len(p.Syms))
+	p.Syms = append(p.Syms, sym)
+	p.Shdr.Size += IpltEntrySize
+}
+
+func (p *OutputIpltWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[p.Shdr.Offset:]
+	for idx, sym := range p.Syms {
+		loc := base[idx*IpltEntrySize:]
+		val := sym.GetGotPltAddr(ctx) - sym.GetPltAddr(ctx)
+		utils.Write[uint32](loc, 0x00000e17)      // auipc t3, 0
+		utils.Write[uint32](loc[4:], 0x000e3e03)  // ld t3, 0(t3)
+		utils.Write[uint32](loc[8:], 0x000e0367)  // jalr t1, t3
+		utils.Write[uint32](loc[12:], 0x00000013) // nop
+		writeUtype(loc, uint32(val))
+		writeItype(loc[4:], uint32(val))
+	}
+}
@@ -2,6 +2,7 @@ package linker
 
 import (
 	"debug/elf"
+	"fmt"
 	"math"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
 )
@@ -81,6 +82,11 @@ func (o *OutputPhdrsWriter) CreatePhdrs(ctx *Context) {
 	// phdr segment
 	define(uint32(elf.PT_PHDR), uint32(elf.PF_R), 8, ctx.OutputPhdrsWriter)
 
+	// interp segment, the kernel loads the dynamic loader it names
+	if ctx.OutputInterpWriter != nil {
+		define(uint32(elf.PT_INTERP), uint32(elf.PF_R), 1, ctx.OutputInterpWriter)
+	}
+
 	// note segment
 	for i := 0; i < len(ctx.OutputWriters); {
 		iCurr := ctx.OutputWriters[i]
@@ -139,6 +145,11 @@ func (o *OutputPhdrsWriter) CreatePhdrs(ctx *Context) {
 		}
 	}
 
+	// dynamic segment
+	if ctx.OutputDynamicWriter != nil {
+		define(uint32(elf.PT_DYNAMIC), uint32(elf.PF_R|elf.PF_W), 8, ctx.OutputDynamicWriter)
+	}
+
 	// tls segment
 	for i := 0; i < len(ctx.OutputWriters); i++ {
 		curr := ctx.OutputWriters[i]
@@ -155,6 +166,40 @@ func (o *OutputPhdrsWriter) CreatePhdrs(ctx *Context) {
 		}
 
 		phdr := &o.Phdrs[len(o.Phdrs)-1]
+		// tp offsets are relative to VAddr, but tp is aligned to Align at runtime
+		if phdr.VAddr%phdr.Align != 0 {
+			utils.Fatal(fmt.Sprintf("PT_TLS at 0x%x is not aligned to 0x%x", phdr.VAddr, phdr.Align))
+		}
 		ctx.TLSSegmentAddr = phdr.VAddr
 	}
+
+	// relro segment, made read-only by the dynamic loader (or the libc of
+	// a static executable) after the relocations are applied
+	// it ends at a page boundary, pages are protected as a whole
+	for i := 0; i < len(outputWriters); i++ {
+		if !isRELRO(ctx, outputWriters[i]) {
+			continue
+		}
+		// the number of headers is fixed before the addresses are,
+		// so sizes decide whether the segment is empty
+		size := outputWriters[i].GetShdr().Size
+		define(uint32(elf.PT_GNU_RELRO), uint32(elf.PF_R), 1, outputWriters[i])
+		for i++; i < len(outputWriters) && isRELRO(ctx, outputWriters[i]); i++ {
+			size += outputWriters[i].GetShdr().Size
+			push(outputWriters[i])
+		}
+		if size == 0 {
+			o.Phdrs = o.Phdrs[:len(o.Phdrs)-1]
+			break
+		}
+		phdr := &o.Phdrs[len(o.Phdrs)-1]
+		phdr.MemSize = utils.AlignTo(phdr.VAddr+phdr.MemSize, PageSize) - phdr.VAddr
+		break
+	}
+
+	// eh_frame_hdr segment, used by the unwinder to find FDEs
+	if ctx.OutputEhFrameHdrWriter != nil {
+		define(uint32(elf.PT_GNU_EH_FRAME), uint32(elf.PF_R), 4,
+			ctx.OutputEhFrameHdrWriter)
+	}
 }
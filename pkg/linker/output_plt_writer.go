@@ -0,0 +1,64 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+const PltHdrSize = 32
+
+// auipc t3, hi; ld t3, lo(t3); jalr t1, t3; nop
+const PltEntrySize = 16
+
+// .plt has a stub for each function of a shared object called by the output
+// the stub jumps through its .got.plt slot, which points to the header
+// until the function is bound, the header calls the dynamic loader
+// with the index of the slot (lazy binding)
+type OutputPltWriter struct {
+	OutputWriter
+	Syms []*Symbol
+}
+
+func NewOutputPltWriter() *OutputPltWriter {
+	p := &OutputPltWriter{OutputWriter: *NewOutputWriter()}
+	p.Name = ".plt"
+	p.Shdr.Type = uint32(elf.SHT_PROGBITS)
+	p.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR)
+	p.Shdr.AddrAlign = 16
+	return p
+}
+
+func (p *OutputPltWriter) AddSym(sym *Symbol) {
+	sym.PltIdx = uint32(len(p.Syms))
+	p.Syms = append(p.Syms, sym)
+	p.Shdr.Size = PltHdrSize + uint64(len(p.Syms)*PltEntrySize)
+}
+
+func (p *OutputPltWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[p.Shdr.Offset:]
+
+	// t1 is the address after the jalr of the stub, t3 is the header
+	gotPlt := ctx.OutputGotPltWriter.Shdr.Addr - p.Shdr.Addr
+	utils.Write[uint32](base, 0x00000397)      // auipc t2, %hi(.got.plt)
+	utils.Write[uint32](base[4:], 0x41c30333)  // sub t1, t1, t3
+	utils.Write[uint32](base[8:], 0x0003be03)  // ld t3, %lo(.got.plt)(t2), the resolver
+	utils.Write[uint32](base[12:], 0xfd430313) // addi t1, t1, -(PltHdrSize + 12)
+	utils.Write[uint32](base[16:], 0x00038293) // addi t0, t2, %lo(.got.plt)
+	utils.Write[uint32](base[20:], 0x00135313) // srli t1, t1, 1, offset of the slot
+	utils.Write[uint32](base[24:], 0x0082b283) // ld t0, 8(t0), the link map
+	utils.Write[uint32](base[28:], 0x000e0067) // jr t3
+	writeUtype(base, uint32(gotPlt))
+	writeItype(base[8:], uint32(gotPlt))
+	writeItype(base[16:], uint32(gotPlt))
+
+	for _, sym := range p.Syms {
+		loc := base[PltHdrSize+sym.PltIdx*PltEntrySize:]
+		val := sym.GetGotPltAddr(ctx) - sym.GetPltAddr(ctx)
+		utils.Write[uint32](loc, 0x00000e17)      // auipc t3, 0
+		utils.Write[uint32](loc[4:], 0x000e3e03)  // ld t3, 0(t3)
+		utils.Write[uint32](loc[8:], 0x000e0367)  // jalr t1, t3
+		utils.Write[uint32](loc[12:], 0x00000013) // nop
+		writeUtype(loc, uint32(val))
+		writeItype(loc[4:], uint32(val))
+	}
+}
@@ -0,0 +1,166 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .rela.dyn has the relocations the dynamic loader applies at startup,
+// got entries and data of preemptible symbols, copies of data (R_RISCV_COPY),
+// addresses in a position independent output (R_RISCV_RELATIVE) and ifuncs
+// relative ones go first, ifuncs last since their resolvers may use the others
+type OutputRelaDynWriter struct {
+	OutputWriter
+	CopyrelSyms []*Symbol
+}
+
+func NewOutputRelaDynWriter() *OutputRelaDynWriter {
+	r := &OutputRelaDynWriter{OutputWriter: *NewOutputWriter()}
+	r.Name = ".rela.dyn"
+	r.Shdr.Type = uint32(elf.SHT_RELA)
+	r.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	r.Shdr.EntSize = uint64(RelaSize)
+	r.Shdr.AddrAlign = 8
+	return r
+}
+
+// the relocation of a got entry, if the value is only known at runtime
+func getGotRel(ctx *Context, e GotEntry, addr uint64) (Rela, bool) {
+	sym := e.Sym
+	rel := Rela{Offset: addr}
+	if sym.IsPreemptible(ctx) {
+		rel.Sym = sym.DynsymIdx
+		switch e.Kind {
+		case GotEntryAddr:
+			rel.Type = uint32(elf.R_RISCV_64)
+		case GotEntryTp:
+			rel.Type = uint32(elf.R_RISCV_TLS_TPREL64)
+		case GotEntryTlsModule:
+			rel.Type = uint32(elf.R_RISCV_TLS_DTPMOD64)
+		case GotEntryDtpOff:
+			rel.Type = uint32(elf.R_RISCV_TLS_DTPREL64)
+		case GotEntryTlsDesc:
+			rel.Type = uint32(R_RISCV_TLSDESC)
+		default:
+			return rel, false
+		}
+		return rel, true
+	}
+
+	switch e.Kind {
+	case GotEntryAddr:
+		// undefined weak symbols stay zero
+		if !ctx.IsPic() || sym.File == nil ||
+			(sym.File != ctx.InternalObj && sym.InputSection == nil &&
+				sym.SectionFragment == nil && sym.GetElfSym().IsAbs()) {
+			return rel, false
+		}
+		rel.Type = uint32(elf.R_RISCV_RELATIVE)
+		if sym.Flags&NeedsPlt != 0 {
+			rel.Addend = int64(sym.GetPltAddr(ctx))
+		} else {
+			rel.Addend = int64(sym.GetAddr())
+		}
+		return rel, true
+	case GotEntryTp:
+		// the tls block of a shared object is placed by the dynamic loader
+		if !ctx.Args.Shared {
+			return rel, false
+		}
+		rel.Type = uint32(elf.R_RISCV_TLS_TPREL64)
+		rel.Addend = int64(sym.GetAddr() - ctx.TLSSegmentAddr)
+		return rel, true
+	case GotEntryTlsModule:
+		// the module id of an executable is always 1
+		if !ctx.Args.Shared {
+			return rel, false
+		}
+		rel.Type = uint32(elf.R_RISCV_TLS_DTPMOD64)
+		return rel, true
+	case GotEntryTlsDesc:
+		// the offset in the tls block of this module
+		rel.Type = uint32(R_RISCV_TLSDESC)
+		rel.Addend = int64(sym.GetAddr() - ctx.TLSSegmentAddr)
+		return rel, true
+	case GotEntryIfunc:
+		rel.Type = uint32(R_RISCV_IRELATIVE)
+		rel.Addend = int64(sym.GetAddr())
+		return rel, true
+	}
+	return rel, false
+}
+
+// the addresses are only right after the layout is done,
+// but the number of relocations is known before
+// with -z pack-relative-relocs, relative relocations of aligned words are
+// returned as offsets for .relr.dyn instead
+func (r *OutputRelaDynWriter) getRels(ctx *Context) ([]Rela, []uint64) {
+	relative := make([]Rela, 0)
+	rels := make([]Rela, 0)
+	irelative := make([]Rela, 0)
+	relr := make([]uint64, 0)
+	// whether the word is aligned doesn't depend on the layout,
+	// otherwise the number of relocations could change with it
+	add := func(rel Rela, aligned bool) {
+		switch rel.Type {
+		case uint32(elf.R_RISCV_RELATIVE):
+			if aligned && ctx.OutputRelrDynWriter != nil {
+				relr = append(relr, rel.Offset)
+				return
+			}
+			relative = append(relative, rel)
+		case uint32(R_RISCV_IRELATIVE):
+			irelative = append(irelative, rel)
+		default:
+			rels = append(rels, rel)
+		}
+	}
+
+	got := ctx.OutputGotSectionWriter
+	for idx, e := range got.Entries {
+		if rel, ok := getGotRel(ctx, e, got.Shdr.Addr+uint64(idx*8)); ok {
+			add(rel, true)
+		}
+	}
+
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsAlive {
+				continue
+			}
+			rels := isec.GetRels()
+			for _, idx := range isec.DynRels {
+				rel := &rels[idx]
+				sym := file.Symbols[rel.Sym]
+				offset := isec.GetAddr() + isec.GetRelaxedOffset(rel.Offset)
+				if sym.IsPreemptible(ctx) {
+					add(Rela{Offset: offset, Type: uint32(elf.R_RISCV_64), Sym: sym.DynsymIdx,
+						Addend: int64(file.GetRelAddend(rel))}, false)
+					continue
+				}
+				S, A := file.GetRelSymbolAddrAndAddend(ctx, rel)
+				add(Rela{Offset: offset, Type: uint32(elf.R_RISCV_RELATIVE), Addend: int64(S + A)},
+					isec.P2Align >= 3 && rel.Offset%8 == 0)
+			}
+		}
+	}
+
+	for _, sym := range r.CopyrelSyms {
+		add(Rela{Offset: sym.GetAddr(), Type: uint32(elf.R_RISCV_COPY), Sym: sym.DynsymIdx}, false)
+	}
+	return append(append(relative, rels...), irelative...), relr
+}
+
+func (r *OutputRelaDynWriter) UpdateSize(ctx *Context) {
+	rels, _ := r.getRels(ctx)
+	r.Shdr.Size = uint64(len(rels) * RelaSize)
+	r.Shdr.Link = uint32(ctx.OutputDynsymWriter.Shndx)
+}
+
+func (r *OutputRelaDynWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[r.Shdr.Offset:]
+	rels, _ := r.getRels(ctx)
+	for idx, rel := range rels {
+		utils.Write[Rela](base[idx*RelaSize:], rel)
+	}
+}
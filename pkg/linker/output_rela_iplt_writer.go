@@ -0,0 +1,39 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .rela.iplt has a R_RISCV_IRELATIVE for each ifunc, there is no dynamic
+// loader in a static executable, so libc applies them before main
+// it finds them with __rela_iplt_start and __rela_iplt_end
+type OutputRelaIpltWriter struct {
+	OutputWriter
+}
+
+func NewOutputRelaIpltWriter() *OutputRelaIpltWriter {
+	r := &OutputRelaIpltWriter{OutputWriter: *NewOutputWriter()}
+	r.Name = ".rela.iplt"
+	r.Shdr.Type = uint32(elf.SHT_RELA)
+	r.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	r.Shdr.EntSize = uint64(RelaSize)
+	r.Shdr.AddrAlign = 8
+	return r
+}
+
+func (r *OutputRelaIpltWriter) UpdateSize(ctx *Context) {
+	r.Shdr.Size = uint64(len(ctx.OutputIpltWriter.Syms) * RelaSize)
+}
+
+// the got slot gets what the resolver at the addend returns
+func (r *OutputRelaIpltWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[r.Shdr.Offset:]
+	for idx, sym := range ctx.OutputIpltWriter.Syms {
+		utils.Write[Rela](base[idx*RelaSize:], Rela{
+			Offset: sym.GetGotPltAddr(ctx),
+			Type:   uint32(R_RISCV_IRELATIVE),
+			Addend: int64(sym.GetAddr()),
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .rela.plt has a R_RISCV_JUMP_SLOT for each .got.plt slot,
+// applied when the function is called the first time
+type OutputRelaPltWriter struct {
+	OutputWriter
+}
+
+func NewOutputRelaPltWriter() *OutputRelaPltWriter {
+	r := &OutputRelaPltWriter{OutputWriter: *NewOutputWriter()}
+	r.Name = ".rela.plt"
+	r.Shdr.Type = uint32(elf.SHT_RELA)
+	r.Shdr.Flags = uint64(elf.SHF_ALLOC | elf.SHF_INFO_LINK)
+	r.Shdr.EntSize = uint64(RelaSize)
+	r.Shdr.AddrAlign = 8
+	return r
+}
+
+// linked to .dynsym, and the slots are in .got.plt
+func (r *OutputRelaPltWriter) UpdateSize(ctx *Context) {
+	r.Shdr.Size = uint64(len(ctx.OutputPltWriter.Syms) * RelaSize)
+	r.Shdr.Link = uint32(ctx.OutputDynsymWriter.Shndx)
+	r.Shdr.Info = uint32(ctx.OutputGotPltWriter.Shndx)
+}
+
+func (r *OutputRelaPltWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[r.Shdr.Offset:]
+	for idx, sym := range ctx.OutputPltWriter.Syms {
+		utils.Write[Rela](base[idx*RelaSize:], Rela{
+			Offset: sym.GetGotPltAddr(ctx),
+			Type:   uint32(elf.R_RISCV_JUMP_SLOT),
+			Sym:    sym.DynsymIdx,
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"sort"
+)
+
+// .relr.dyn packs relative relocations of aligned words, the addend is
+// the word itself
+// an even entry is an address to relocate, and an odd one is a bitmap of
+// the 63 words after the last address (bit 0 marks the bitmap)
+type OutputRelrDynWriter struct {
+	OutputWriter
+}
+
+func NewOutputRelrDynWriter() *OutputRelrDynWriter {
+	r := &OutputRelrDynWriter{OutputWriter: *NewOutputWriter()}
+	r.Name = ".relr.dyn"
+	r.Shdr.Type = SHT_RELR
+	r.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	r.Shdr.EntSize = 8
+	r.Shdr.AddrAlign = 8
+	return r
+}
+
+func encodeRelr(offsets []uint64) []uint64 {
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	entries := make([]uint64, 0)
+	for i := 0; i < len(offsets); {
+		entries = append(entries, offsets[i])
+		base := offsets[i] + 8
+		i++
+		for {
+			bitmap := uint64(0)
+			for ; i < len(offsets) && offsets[i]-base < 63*8; i++ {
+				bitmap |= 1 << ((offsets[i]-base)/8 + 1)
+			}
+			if bitmap == 0 {
+				break
+			}
+			entries = append(entries, bitmap|1)
+			base += 63 * 8
+		}
+	}
+	return entries
+}
+
+// the size never shrinks, otherwise the layout may never converge,
+// what is left is filled with empty bitmaps
+func (r *OutputRelrDynWriter) UpdateSize(ctx *Context) {
+	_, offsets := ctx.OutputRelaDynWriter.getRels(ctx)
+	size := uint64(len(encodeRelr(offsets)) * 8)
+	if size > r.Shdr.Size {
+		r.Shdr.Size = size
+	}
+}
+
+func (r *OutputRelrDynWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[r.Shdr.Offset:]
+	_, offsets := ctx.OutputRelaDynWriter.getRels(ctx)
+	entries := encodeRelr(offsets)
+	for idx := uint64(0); idx < r.Shdr.Size/8; idx++ {
+		if idx < uint64(len(entries)) {
+			utils.Write[uint64](base[idx*8:], entries[idx])
+		} else {
+			utils.Write[uint64](base[idx*8:], 1)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package linker
+
+import (
+	"debug/elf"
+)
+
+// section name string table, every writer owning a section header
+// gets its name offset from here
+type OutputShStrtabWriter struct {
+	OutputWriter
+	Content []byte
+}
+
+func NewOutputShStrtabWriter() *OutputShStrtabWriter {
+	s := &OutputShStrtabWriter{OutputWriter: *NewOutputWriter()}
+	s.Name = ".shstrtab"
+	s.Shdr.Type = uint32(elf.SHT_STRTAB)
+	return s
+}
+
+// should be called after shndxs are assigned
+// the first byte is always null (index 0 means no name)
+func (s *OutputShStrtabWriter) UpdateSize(ctx *Context) {
+	s.Content = []byte{0}
+	for _, o := range ctx.OutputWriters {
+		if o.GetShndx() == 0 {
+			continue
+		}
+		o.GetShdr().Name = uint32(len(s.Content))
+		s.Content = append(s.Content, o.GetName()...)
+		s.Content = append(s.Content, 0)
+	}
+	s.Shdr.Size = uint64(len(s.Content))
+}
+
+func (s *OutputShStrtabWriter) CopyBuf(ctx *Context) {
+	copy(ctx.Buf[s.Shdr.Offset:], s.Content)
+}
@@ -0,0 +1,46 @@
+package linker
+
+import (
+	"debug/elf"
+)
+
+// symbol name string table, filled in by the symtab writer
+type OutputStrtabWriter struct {
+	OutputWriter
+	Content []byte
+}
+
+func NewOutputStrtabWriter() *OutputStrtabWriter {
+	s := &OutputStrtabWriter{OutputWriter: *NewOutputWriter()}
+	s.Name = ".strtab"
+	s.Shdr.Type = uint32(elf.SHT_STRTAB)
+	s.Content = []byte{0}
+	s.Shdr.Size = 1
+	return s
+}
+
+// .dynstr is built like .strtab, but loaded at runtime
+func NewOutputDynstrWriter() *OutputStrtabWriter {
+	s := NewOutputStrtabWriter()
+	s.Name = ".dynstr"
+	s.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	return s
+}
+
+func (s *OutputStrtabWriter) Reset() {
+	s.Content = []byte{0}
+	s.Shdr.Size = 1
+}
+
+// returns the offset of the name inside the table
+func (s *OutputStrtabWriter) AddString(name string) uint32 {
+	offset := uint32(len(s.Content))
+	s.Content = append(s.Content, name...)
+	s.Content = append(s.Content, 0)
+	s.Shdr.Size = uint64(len(s.Content))
+	return offset
+}
+
+func (s *OutputStrtabWriter) CopyBuf(ctx *Context) {
+	copy(ctx.Buf[s.Shdr.Offset:], s.Content)
+}
@@ -0,0 +1,123 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"sort"
+)
+
+// .symtab of the output file
+// locals come first (required by elf), then globals from ctx.SymbolMap
+type OutputSymtabWriter struct {
+	OutputWriter
+	Symbols []*Symbol
+	StrOffs []uint32
+}
+
+func NewOutputSymtabWriter() *OutputSymtabWriter {
+	s := &OutputSymtabWriter{OutputWriter: *NewOutputWriter()}
+	s.Name = ".symtab"
+	s.Shdr.Type = uint32(elf.SHT_SYMTAB)
+	s.Shdr.EntSize = uint64(SymSize)
+	s.Shdr.AddrAlign = 8
+	return s
+}
+
+// symbols in discarded sections (e.g. .eh_frame) and section symbols are not written,
+// neither are symbols of fragments removed by --gc-sections
+func shouldWriteSymbol(sym *Symbol) bool {
+	if sym.File == nil || !sym.File.IsAlive || sym.Name == "" {
+		return false
+	}
+	// symbols of shared objects are only listed if copied into the output
+	if sym.File.IsDso && sym.InputSection == nil {
+		return false
+	}
+	esym := sym.GetElfSym()
+	if esym.Type() == uint8(elf.STT_SECTION) || esym.IsUndef() {
+		return false
+	}
+	if sym.SectionFragment != nil {
+		return sym.SectionFragment.IsAlive
+	}
+	if esym.IsAbs() {
+		return true
+	}
+	return sym.InputSection != nil && sym.InputSection.IsAlive
+}
+
+// should be called after shndxs are assigned,
+// since the strtab link is needed
+func (s *OutputSymtabWriter) UpdateSize(ctx *Context) {
+	s.Symbols = []*Symbol{nil} // first symbol is empty
+	s.StrOffs = []uint32{0}
+	strtab := ctx.OutputStrtabWriter
+	strtab.Reset()
+
+	for _, file := range ctx.Args.ObjFiles {
+		for _, sym := range file.LocalSymbols {
+			if shouldWriteSymbol(sym) {
+				s.Symbols = append(s.Symbols, sym)
+				s.StrOffs = append(s.StrOffs, strtab.AddString(sym.Name))
+			}
+		}
+	}
+	firstGlobal := len(s.Symbols)
+
+	// map iteration order is random, sort to make output stable
+	names := make([]string, 0, len(ctx.SymbolMap))
+	for name := range ctx.SymbolMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sym := ctx.SymbolMap[name]
+		if shouldWriteSymbol(sym) {
+			s.Symbols = append(s.Symbols, sym)
+			s.StrOffs = append(s.StrOffs, strtab.AddString(sym.Name))
+		}
+	}
+
+	s.Shdr.Link = uint32(strtab.Shndx)
+	s.Shdr.Info = uint32(firstGlobal)
+	s.Shdr.Size = uint64(len(s.Symbols) * SymSize)
+}
+
+// section index is the shndx of the output section the symbol is in
+func getOutputShndx(sym *Symbol) uint16 {
+	if sym.SectionFragment != nil {
+		return uint16(sym.SectionFragment.OutputSection.Shndx)
+	}
+	if sym.InputSection != nil {
+		return uint16(sym.InputSection.OutputSection.Shndx)
+	}
+	return uint16(elf.SHN_ABS)
+}
+
+func (s *OutputSymtabWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[s.Shdr.Offset:]
+	utils.Write[Sym](base, Sym{})
+
+	for idx := 1; idx < len(s.Symbols); idx++ {
+		sym := s.Symbols[idx]
+		esym := sym.GetElfSym()
+		out := Sym{
+			Name:  s.StrOffs[idx],
+			Info:  esym.Info,
+			Other: esym.Other,
+			Shndx: getOutputShndx(sym),
+			Val:   sym.GetAddr(),
+			Size:  esym.Size,
+		}
+		// functions shrink if their instructions are relaxed
+		if isec := sym.InputSection; isec != nil && isec.IsRelaxable() {
+			out.Size = isec.GetRelaxedOffset(esym.Val+esym.Size) -
+				isec.GetRelaxedOffset(esym.Val)
+		}
+		// tls symbols hold the offset inside the tls segment
+		if esym.Type() == uint8(elf.STT_TLS) {
+			out.Val -= ctx.TLSSegmentAddr
+		}
+		utils.Write[Sym](base[idx*SymSize:], out)
+	}
+}
@@ -0,0 +1,62 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"path/filepath"
+)
+
+// .gnu.version_d has the versions the output defines, the version nodes
+// of --version-script after the base version (the output itself)
+type OutputVerdefWriter struct {
+	OutputWriter
+	Names []string
+	Offs  []uint32 // names in .dynstr
+}
+
+func NewOutputVerdefWriter(ctx *Context) *OutputVerdefWriter {
+	v := &OutputVerdefWriter{OutputWriter: *NewOutputWriter()}
+	v.Name = ".gnu.version_d"
+	v.Shdr.Type = uint32(elf.SHT_GNU_VERDEF)
+	v.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	v.Shdr.AddrAlign = 8
+
+	base := ctx.Args.Soname
+	if base == "" {
+		base = filepath.Base(ctx.Args.Output)
+	}
+	v.Names = append([]string{base}, ctx.VersionNames...)
+	for _, name := range v.Names {
+		v.Offs = append(v.Offs, ctx.OutputDynstrWriter.AddString(name))
+	}
+	return v
+}
+
+// a name for each version, parents are not listed
+func (v *OutputVerdefWriter) UpdateSize(ctx *Context) {
+	v.Shdr.Size = uint64(len(v.Names) * (VerdefSize + VerdauxSize))
+	v.Shdr.Link = uint32(ctx.OutputDynstrWriter.Shndx)
+	v.Shdr.Info = uint32(len(v.Names))
+}
+
+func (v *OutputVerdefWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[v.Shdr.Offset:]
+	for idx, name := range v.Names {
+		vd := Verdef{
+			Version: 1,
+			Ndx:     uint16(idx + 1),
+			Cnt:     1,
+			Hash:    elfHash(name),
+			Aux:     uint32(VerdefSize),
+		}
+		if idx == 0 {
+			vd.Flags = VER_FLG_BASE
+		}
+		if idx != len(v.Names)-1 {
+			vd.Next = uint32(VerdefSize + VerdauxSize)
+		}
+		utils.Write[Verdef](base, vd)
+		utils.Write[Verdaux](base[VerdefSize:], Verdaux{Name: v.Offs[idx]})
+		base = base[VerdefSize+VerdauxSize:]
+	}
+}
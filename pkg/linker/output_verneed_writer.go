@@ -0,0 +1,108 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// .gnu.version_r has the versions needed from each shared object, e.g.
+// memcpy@GLIBC_2.27 of libc.so.6, the dynamic loader checks they exist
+// the indexes follow the ones of .gnu.version_d
+type OutputVerneedWriter struct {
+	OutputWriter
+	Needs   []*VersionNeed
+	NextIdx uint16
+}
+
+type VersionNeed struct {
+	File *ObjectFile
+	Vers []Vernaux
+	Idxs map[uint16]uint16 // version of the shared object to the index in the output
+}
+
+func NewOutputVerneedWriter(ctx *Context) *OutputVerneedWriter {
+	v := &OutputVerneedWriter{OutputWriter: *NewOutputWriter()}
+	v.Name = ".gnu.version_r"
+	v.Shdr.Type = uint32(elf.SHT_GNU_VERNEED)
+	v.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	v.Shdr.AddrAlign = 8
+	v.NextIdx = uint16(len(ctx.VersionNames) + 2)
+	return v
+}
+
+// ver is an index of the shared object's .gnu.version_d
+func (v *OutputVerneedWriter) AddVersion(ctx *Context, file *ObjectFile, ver uint16) uint16 {
+	var need *VersionNeed
+	for _, n := range v.Needs {
+		if n.File == file {
+			need = n
+		}
+	}
+	if need == nil {
+		need = &VersionNeed{File: file, Idxs: make(map[uint16]uint16)}
+		v.Needs = append(v.Needs, need)
+	}
+	if idx, ok := need.Idxs[ver]; ok {
+		return idx
+	}
+
+	name := file.VerNames[ver]
+	need.Vers = append(need.Vers, Vernaux{
+		Hash:  elfHash(name),
+		Other: v.NextIdx,
+		Name:  ctx.OutputDynstrWriter.AddString(name),
+	})
+	need.Idxs[ver] = v.NextIdx
+	v.NextIdx++
+	return need.Idxs[ver]
+}
+
+// symbols without a version (or with the base one) bind to any
+func (v *OutputVerneedWriter) GetOutputVersion(sym *Symbol) uint16 {
+	ver := sym.File.GetSymbolVersion(sym.SymIdx)
+	if v == nil || ver <= VER_NDX_GLOBAL {
+		return VER_NDX_GLOBAL
+	}
+	for _, n := range v.Needs {
+		if n.File == sym.File {
+			return n.Idxs[ver]
+		}
+	}
+	return VER_NDX_GLOBAL
+}
+
+func (v *OutputVerneedWriter) UpdateSize(ctx *Context) {
+	size := 0
+	for _, n := range v.Needs {
+		size += VerneedSize + len(n.Vers)*VernauxSize
+	}
+	v.Shdr.Size = uint64(size)
+	v.Shdr.Link = uint32(ctx.OutputDynstrWriter.Shndx)
+	v.Shdr.Info = uint32(len(v.Needs))
+}
+
+// the file is named by the DT_NEEDED string of the shared object
+func (v *OutputVerneedWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[v.Shdr.Offset:]
+	for idx, n := range v.Needs {
+		vn := Verneed{
+			Version: 1,
+			Cnt:     uint16(len(n.Vers)),
+			File:    ctx.OutputDynamicWriter.GetNeeded(ctx, n.File),
+			Aux:     uint32(VerneedSize),
+		}
+		if idx != len(v.Needs)-1 {
+			vn.Next = uint32(VerneedSize + len(n.Vers)*VernauxSize)
+		}
+		utils.Write[Verneed](base, vn)
+		base = base[VerneedSize:]
+
+		for i, aux := range n.Vers {
+			if i != len(n.Vers)-1 {
+				aux.Next = uint32(VernauxSize)
+			}
+			utils.Write[Vernaux](base, aux)
+			base = base[VernauxSize:]
+		}
+	}
+}
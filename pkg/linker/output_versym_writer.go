@@ -0,0 +1,50 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"strings"
+)
+
+// .gnu.version has the version of each .dynsym entry, an index into
+// .gnu.version_d for symbols of the output and into .gnu.version_r
+// for the ones of shared objects
+type OutputVersymWriter struct {
+	OutputWriter
+}
+
+func NewOutputVersymWriter() *OutputVersymWriter {
+	v := &OutputVersymWriter{OutputWriter: *NewOutputWriter()}
+	v.Name = ".gnu.version"
+	v.Shdr.Type = uint32(elf.SHT_GNU_VERSYM)
+	v.Shdr.Flags = uint64(elf.SHF_ALLOC)
+	v.Shdr.EntSize = 2
+	v.Shdr.AddrAlign = 2
+	return v
+}
+
+func getOutputVersym(ctx *Context, sym *Symbol) uint16 {
+	switch {
+	case sym.File == nil:
+		return VER_NDX_GLOBAL
+	case sym.IsImported():
+		return ctx.OutputVerneedWriter.GetOutputVersion(sym)
+	case strings.IndexByte(sym.Name, '@') >= 0:
+		// foo@VER, an old version
+		return sym.VerIdx | VERSYM_HIDDEN
+	}
+	return sym.VerIdx
+}
+
+func (v *OutputVersymWriter) UpdateSize(ctx *Context) {
+	v.Shdr.Size = uint64(len(ctx.OutputDynsymWriter.Symbols) * 2)
+	v.Shdr.Link = uint32(ctx.OutputDynsymWriter.Shndx)
+}
+
+// the null symbol is local
+func (v *OutputVersymWriter) CopyBuf(ctx *Context) {
+	base := ctx.Buf[v.Shdr.Offset:]
+	for idx := 1; idx < len(ctx.OutputDynsymWriter.Symbols); idx++ {
+		utils.Write[uint16](base[idx*2:], getOutputVersym(ctx, ctx.OutputDynsymWriter.Symbols[idx]))
+	}
+}
@@ -1,6 +1,9 @@
 package linker
 
-import "debug/elf"
+import (
+	"debug/elf"
+	"strings"
+)
 
 type iOutputWriter interface {
 	GetShdr() *Shdr
@@ -8,6 +11,7 @@ type iOutputWriter interface {
 	GetName() string
 	UpdateSize(ctx *Context)
 	GetShndx() int64
+	SetShndx(shndx int64)
 }
 
 type OutputWriter struct {
@@ -36,6 +40,10 @@ func (o *OutputWriter) GetShndx() int64 {
 	return o.Shndx
 }
 
+func (o *OutputWriter) SetShndx(shndx int64) {
+	o.Shndx = shndx
+}
+
 func (o *OutputWriter) UpdateSize(ctx *Context) {
 	// left empty for successor to implement
 }
@@ -52,6 +60,31 @@ func isBSS(o iOutputWriter) bool {
 	return o.GetShdr().Type == uint32(elf.SHT_NOBITS) && !isTLS(o)
 }
 
+// written only by the dynamic loader, so read-only after the relocations
+// are applied (-z relro, PT_GNU_RELRO)
+func isRELRO(ctx *Context, o iOutputWriter) bool {
+	shdr := o.GetShdr()
+	if !ctx.Args.Relro || shdr.Flags&uint64(elf.SHF_ALLOC) == 0 ||
+		shdr.Flags&uint64(elf.SHF_WRITE) == 0 {
+		return false
+	}
+	// lazy binding writes the slot when the function is called first
+	if o == ctx.OutputGotPltWriter {
+		return ctx.Args.ZNow
+	}
+	if isTLS(o) || o == ctx.OutputGotSectionWriter {
+		return true
+	}
+	switch elf.SectionType(shdr.Type) {
+	case elf.SHT_INIT_ARRAY, elf.SHT_FINI_ARRAY, elf.SHT_PREINIT_ARRAY, elf.SHT_DYNAMIC:
+		return true
+	}
+	name := o.GetName()
+	return name == ".data.rel.ro" || name == ".bss.rel.ro" || name == ".init_array" ||
+		name == ".fini_array" || name == ".preinit_array" ||
+		strings.HasPrefix(name, ".ctors") || strings.HasPrefix(name, ".dtors")
+}
+
 func isNOTE(o iOutputWriter) bool {
 	return o.GetShdr().Type == uint32(elf.SHT_NOTE) &&
 		o.GetShdr().Flags&uint64(elf.SHF_ALLOC) != 0
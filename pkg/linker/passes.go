@@ -2,11 +2,383 @@ package linker
 
 import (
 	"debug/elf"
+	"fmt"
 	"github.com/hcyang1106/simple-linker/pkg/utils"
 	"math"
 	"sort"
+	"strings"
 )
 
+// called twice, before and after MarkLiveObjects
+// the second time only live files are left, and definitions of pulled out
+// archive members could now beat weak or common ones
+func ResolveSymbols(ctx *Context) {
+	// symbols owned by dead files or discarded sections are no longer defined
+	for _, sym := range ctx.SymbolMap {
+		if sym.File != nil &&
+			(!sym.File.IsAlive || sym.File.IsSymbolDiscarded(sym.SymIdx)) {
+			sym.File = nil
+		}
+	}
+	for _, file := range ctx.Args.ObjFiles {
+		file.ResolveSymbols()
+	}
+
+	// e.g. a hidden reference keeps a default symbol of another file
+	// from being exported, shared objects don't take part
+	for _, sym := range ctx.SymbolMap {
+		sym.Visibility = uint8(elf.STV_DEFAULT)
+	}
+	for _, file := range ctx.Args.ObjFiles {
+		if file.IsAlive && !file.IsDso {
+			file.MergeVisibility()
+		}
+	}
+}
+
+// should be called after MarkLiveObjects, the first live file with a group wins
+// and members of the other copies are discarded
+func ResolveComdatGroups(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		for _, group := range file.ComdatGroups {
+			if _, ok := ctx.ComdatGroups[group.Signature]; !ok {
+				ctx.ComdatGroups[group.Signature] = group
+				continue
+			}
+			for _, shndx := range group.Members {
+				file.DiscardedSecs[shndx] = true
+			}
+		}
+	}
+}
+
+func CheckDuplicateSymbols(ctx *Context) {
+	if ctx.Args.AllowMultipleDefinition {
+		return
+	}
+
+	errs := make([]string, 0)
+	for _, file := range ctx.Args.ObjFiles {
+		errs = append(errs, file.CheckDuplicateSymbols()...)
+	}
+	for _, err := range errs {
+		utils.Error(err)
+	}
+	if len(errs) > 0 {
+		utils.Fatal("duplicate symbols found")
+	}
+}
+
+// commons of the same name become one, with the largest size and
+// the strictest alignment (Val of a common symbol is its alignment)
+// a real definition always wins since it has a better rank
+func MergeCommonSymbols(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			esym := &file.ElfSyms[i]
+			sym := file.Symbols[i]
+			if !esym.IsCommon() || sym.File == file {
+				continue
+			}
+
+			owner := sym.GetElfSym()
+			if !owner.IsCommon() {
+				if ctx.Args.WarnCommon {
+					utils.Warn(file.GetFileName() + ": common of `" + sym.Name +
+						"' overridden by definition from " + sym.File.GetFileName())
+				}
+				continue
+			}
+			if ctx.Args.WarnCommon {
+				utils.Warn(file.GetFileName() + ": multiple common of `" + sym.Name +
+					"', first from " + sym.File.GetFileName())
+			}
+			owner.Size = max(owner.Size, esym.Size)
+			owner.Val = max(owner.Val, esym.Val)
+		}
+	}
+}
+
+// all commons are put into one .bss section owned by the internal file
+// so that --sort-common could sort them across files
+func AllocateCommonSymbols(ctx *Context) {
+	syms := make([]*Symbol, 0)
+	for _, file := range ctx.Args.ObjFiles {
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			sym := file.Symbols[i]
+			if sym.File == file && file.ElfSyms[i].IsCommon() {
+				syms = append(syms, sym)
+			}
+		}
+	}
+	if len(syms) == 0 {
+		return
+	}
+
+	// larger alignment first saves paddings
+	switch ctx.Args.SortCommon {
+	case "descending":
+		sort.SliceStable(syms, func(i, j int) bool {
+			return syms[i].GetElfSym().Val > syms[j].GetElfSym().Val
+		})
+	case "ascending":
+		sort.SliceStable(syms, func(i, j int) bool {
+			return syms[i].GetElfSym().Val < syms[j].GetElfSym().Val
+		})
+	}
+
+	obj := ctx.InternalObj
+	shdr := &Shdr{
+		Type:      uint32(elf.SHT_NOBITS),
+		Flags:     uint64(elf.SHF_ALLOC | elf.SHF_WRITE),
+		AddrAlign: 1,
+	}
+	isec := NewInputSection(obj, nil, uint32(len(obj.InputSections)), shdr, ".bss")
+	isec.P2Align = 0
+
+	offset := uint64(0)
+	for _, sym := range syms {
+		esym := sym.GetElfSym()
+		align := max(esym.Val, 1)
+		offset = utils.AlignTo(offset, align)
+		sym.SetInputSection(isec)
+		sym.SetValue(offset)
+		offset += esym.Size
+		shdr.AddrAlign = max(shdr.AddrAlign, align)
+	}
+	shdr.Size = offset
+
+	isec.SetInputSectionSize(shdr.Size)
+	isec.SetP2Align(shdr.AddrAlign)
+	isec.SetInputSectionOutputSection(isec.GetInputSectionOutputSection(ctx))
+	obj.InputSections = append(obj.InputSections, isec)
+}
+
+// a reference is where an undefined symbol is used, e.g. a.o:(.text+0x8)
+type undefinedSymbol struct {
+	Sym        *Symbol
+	References []string
+}
+
+// find the closest defined name, since typos are the most common reason
+func getSimilarSymbol(ctx *Context, name string) *Symbol {
+	var ret *Symbol
+	best := max(2, len(name)/4) + 1
+	for _, sym := range ctx.SymbolMap {
+		if sym.File == nil || !sym.File.IsAlive {
+			continue
+		}
+		dist := utils.EditDistance(name, sym.Name)
+		if dist < best || (dist == best && ret != nil && sym.Name < ret.Name) {
+			best = dist
+			ret = sym
+		}
+	}
+	return ret
+}
+
+// should be called after linker defined symbols and commons are set up,
+// only references from relocations of alloc sections count
+// weak undefined symbols are fine, they resolve to zero
+func ReportUndefinedSymbols(ctx *Context) {
+	if ctx.Args.UnresolvedSymbols == "ignore-all" ||
+		ctx.Args.UnresolvedSymbols == "ignore-in-object-files" {
+		return
+	}
+
+	undefs := make([]*undefinedSymbol, 0)
+	undefMap := make(map[*Symbol]*undefinedSymbol)
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsAlive ||
+				isec.Shdr.Flags&uint64(elf.SHF_ALLOC) == 0 {
+				continue
+			}
+			for _, rel := range isec.GetRels() {
+				sym := file.Symbols[rel.Sym]
+				esym := &file.ElfSyms[rel.Sym]
+				if rel.Sym < file.FirstGlobal || sym.File != nil ||
+					esym.Bind() == uint8(elf.STB_WEAK) {
+					continue
+				}
+				undef, ok := undefMap[sym]
+				if !ok {
+					undef = &undefinedSymbol{Sym: sym}
+					undefMap[sym] = undef
+					undefs = append(undefs, undef)
+				}
+				undef.References = append(undef.References,
+					fmt.Sprintf("%s:(%s+0x%x)", file.GetFileName(), isec.Name, rel.Offset))
+			}
+		}
+	}
+	if len(undefs) == 0 {
+		return
+	}
+
+	report := utils.Error
+	if ctx.Args.WarnUnresolvedSymbols {
+		report = utils.Warn
+	}
+	for idx, undef := range undefs {
+		if ctx.Args.ErrorLimit > 0 && idx >= ctx.Args.ErrorLimit {
+			report("too many errors emitted, stopping now (use --error-limit=0 to see all errors)")
+			break
+		}
+		msg := "undefined symbol: " + undef.Sym.Name
+		for _, ref := range undef.References {
+			msg += "\n>>> referenced by " + ref
+		}
+		if similar := getSimilarSymbol(ctx, undef.Sym.Name); similar != nil {
+			msg += "\n>>> did you mean: " + similar.Name
+			msg += "\n>>> defined in: " + similar.File.GetFileName()
+		}
+		report(msg)
+	}
+
+	if !ctx.Args.WarnUnresolvedSymbols {
+		utils.Fatal("undefined symbols found")
+	}
+}
+
+// sections that are kept even if nobody references them,
+// like the KEEP() ones in gnu ld's default linker script
+func isGcRoot(ctx *Context, isec *InputSection) bool {
+	switch elf.SectionType(isec.Shdr.Type) {
+	case elf.SHT_INIT_ARRAY, elf.SHT_FINI_ARRAY, elf.SHT_PREINIT_ARRAY, elf.SHT_NOTE:
+		return true
+	}
+	if isec.Shdr.Flags&SHF_GNU_RETAIN != 0 {
+		return true
+	}
+
+	for _, prefix := range []string{".init_array", ".fini_array", ".preinit_array",
+		".ctors", ".dtors", ".init", ".fini", ".jcr"} {
+		if isec.Name == prefix || strings.HasPrefix(isec.Name, prefix+".") {
+			return true
+		}
+	}
+
+	// sections used by __start_SEC and __stop_SEC
+	if isCIdentifier(isec.Name) {
+		_, start := ctx.SymbolMap["__start_"+isec.Name]
+		_, stop := ctx.SymbolMap["__stop_"+isec.Name]
+		return start || stop
+	}
+	return false
+}
+
+// mark and sweep over input sections and fragments, edges are relocations
+// a live function also keeps its FDE's LSDA and personality alive
+// non-alloc sections (e.g. debug info) are always kept
+func GcSections(ctx *Context) {
+	if !ctx.Args.GcSections {
+		return
+	}
+
+	fdes := make(map[*InputSection][]*FdeRecord)
+	for _, file := range ctx.Args.ObjFiles {
+		for _, fde := range file.Fdes {
+			if target := fde.GetTargetSection(); target != nil {
+				fdes[target] = append(fdes[target], fde)
+			}
+		}
+	}
+	// fragments of non-alloc sections (e.g. .debug_str) are referenced by
+	// sections that are never visited, so they are not collected
+	for _, m := range ctx.MergedSections {
+		if m.Shdr.Flags&uint64(elf.SHF_ALLOC) == 0 {
+			continue
+		}
+		for _, frag := range m.Map {
+			frag.SetIsAlive(false)
+		}
+	}
+
+	visited := make(map[*InputSection]bool)
+	queue := make([]*InputSection, 0)
+	markSection := func(isec *InputSection) {
+		if isec != nil && isec.IsAlive && !visited[isec] {
+			visited[isec] = true
+			queue = append(queue, isec)
+		}
+	}
+	markSymbol := func(sym *Symbol) {
+		if sym == nil || sym.File == nil {
+			return
+		}
+		if sym.SectionFragment != nil {
+			sym.SectionFragment.SetIsAlive(true)
+		} else {
+			markSection(sym.InputSection)
+		}
+	}
+	markRels := func(file *ObjectFile, rels []Rela) {
+		for idx := range rels {
+			if frag, _ := file.GetFragmentOfRel(&rels[idx]); frag != nil {
+				frag.SetIsAlive(true)
+				continue
+			}
+			markSymbol(file.Symbols[rels[idx].Sym])
+		}
+	}
+
+	// roots
+	for _, name := range append([]string{ctx.Args.Entry}, ctx.Args.Undefined...) {
+		markSymbol(ctx.SymbolMap[name])
+	}
+	// exported symbols may be used by other modules
+	referenced := getDsoReferences(ctx)
+	for _, sym := range ctx.SymbolMap {
+		if isExportable(sym) &&
+			(ctx.Args.Shared || ctx.Args.ExportDynamic || referenced[sym]) {
+			markSymbol(sym)
+		}
+	}
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec != nil && isGcRoot(ctx, isec) {
+				markSection(isec)
+			}
+		}
+	}
+
+	for len(queue) > 0 {
+		isec := queue[0]
+		queue = queue[1:]
+		markRels(isec.ObjFile, isec.GetRels())
+		for _, fde := range fdes[isec] {
+			markRels(fde.ObjFile, fde.Rels[1:])
+			markRels(fde.Cie.ObjFile, fde.Cie.Rels)
+		}
+	}
+
+	// sweep
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsAlive || visited[isec] ||
+				isec.Shdr.Flags&uint64(elf.SHF_ALLOC) == 0 {
+				continue
+			}
+			isec.IsAlive = false
+			if ctx.Args.PrintGcSections {
+				fmt.Printf("removing unused section '%s' in file '%s'\n",
+					isec.Name, file.GetFileName())
+			}
+		}
+	}
+	for _, m := range ctx.MergedSections {
+		if m.Shdr.Flags&uint64(elf.SHF_ALLOC) == 0 {
+			continue
+		}
+		for key, frag := range m.Map {
+			if !frag.IsAlive {
+				delete(m.Map, key)
+			}
+		}
+	}
+}
+
 func MarkLiveObjects(ctx *Context) {
 	roots := make([]*ObjectFile, 0)
 	for _, file := range ctx.Args.ObjFiles {
@@ -14,6 +386,15 @@ func MarkLiveObjects(ctx *Context) {
 			roots = append(roots, file)
 		}
 	}
+	// entry symbol and -u symbols are treated as undefined references,
+	// so they could be pulled out from an archive
+	for _, name := range append([]string{ctx.Args.Entry}, ctx.Args.Undefined...) {
+		if sym, ok := ctx.SymbolMap[name]; ok &&
+			sym.File != nil && !sym.File.IsAlive {
+			sym.File.IsAlive = true
+			roots = append(roots, sym.File)
+		}
+	}
 	for len(roots) > 0 {
 		roots = roots[0].MarkLiveObjects(ctx, roots)
 		roots = roots[1:]
@@ -66,6 +447,185 @@ func ChangeMSecsSymbolsSection(ctx *Context) {
 	}
 }
 
+// linker defined symbols, only defined if some file references them
+// and no live file defines them
+var internalSymbolNames = []string{
+	"__ehdr_start", "__executable_start", "_etext", "etext", "_edata", "edata",
+	"_end", "end", "__bss_start", "__init_array_start", "__init_array_end",
+	"__fini_array_start", "__fini_array_end", "__preinit_array_start",
+	"__preinit_array_end", "__rela_iplt_start", "__rela_iplt_end", "_DYNAMIC",
+	"_GLOBAL_OFFSET_TABLE_",
+}
+
+func isCIdentifier(name string) bool {
+	for i, c := range name {
+		alpha := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		digit := c >= '0' && c <= '9'
+		if !alpha && !(digit && i > 0) {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
+// should be called after output sections are created
+// __start_SEC and __stop_SEC are only for sections named as a c identifier
+func DefineInternalSymbols(ctx *Context) {
+	// gp is always defined since crt0 uses it to setup gp register
+	// a shared object doesn't set gp, gp relative addresses don't move with it
+	if _, ok := ctx.SymbolMap["__global_pointer$"]; ok || !ctx.Args.Shared {
+		ctx.AddInternalSymbol("__global_pointer$")
+	}
+	// the libc start code of static-pie finds its relocations with it
+	if ctx.IsStaticPie() {
+		ctx.AddInternalSymbol("_DYNAMIC")
+	}
+	for _, name := range internalSymbolNames {
+		if _, ok := ctx.SymbolMap[name]; ok {
+			ctx.AddInternalSymbol(name)
+		}
+	}
+
+	names := make([]string, 0)
+	for _, osec := range ctx.OutputSections {
+		names = append(names, osec.Name)
+	}
+	for _, msec := range ctx.MergedSections {
+		names = append(names, msec.Name)
+	}
+	for _, name := range names {
+		if !isCIdentifier(name) {
+			continue
+		}
+		for _, prefix := range []string{"__start_", "__stop_"} {
+			if _, ok := ctx.SymbolMap[prefix+name]; ok {
+				ctx.AddInternalSymbol(prefix + name)
+			}
+		}
+	}
+}
+
+func getOutputWriterByName(ctx *Context, name string) iOutputWriter {
+	for _, o := range ctx.OutputWriters {
+		if o.GetName() == name && o.GetShndx() > 0 {
+			return o
+		}
+	}
+	return nil
+}
+
+// get called after SetOutputShdrOffsets, since addresses are needed
+// missing sections make both start and end symbols zero (empty range)
+func FixInternalSymbols(ctx *Context) {
+	var textEnd, dataEnd, end, bssStart, dataStart uint64
+	for _, o := range ctx.OutputWriters {
+		shdr := o.GetShdr()
+		if shdr.Flags&uint64(elf.SHF_ALLOC) == 0 || isTBSS(o) {
+			continue
+		}
+		last := shdr.Addr + shdr.Size
+		if shdr.Flags&uint64(elf.SHF_EXECINSTR) != 0 {
+			textEnd = max(textEnd, last)
+		}
+		if shdr.Type != uint32(elf.SHT_NOBITS) {
+			dataEnd = max(dataEnd, last)
+		}
+		if isBSS(o) && bssStart == 0 {
+			bssStart = shdr.Addr
+		}
+		if shdr.Flags&uint64(elf.SHF_WRITE) != 0 && !isTLS(o) &&
+			dataStart == 0 {
+			dataStart = shdr.Addr
+		}
+		end = max(end, last)
+	}
+	if bssStart == 0 {
+		bssStart = dataEnd
+	}
+
+	// gp points to the middle of a 4KiB window, so that both sides can be reached
+	gp := uint64(0)
+	if sdata := getOutputWriterByName(ctx, ".sdata"); sdata != nil {
+		gp = sdata.GetShdr().Addr + 0x800
+	} else if dataStart != 0 {
+		gp = dataStart + 0x800
+	}
+
+	sectionStart := func(name string) uint64 {
+		if o := getOutputWriterByName(ctx, name); o != nil {
+			return o.GetShdr().Addr
+		}
+		return 0
+	}
+	sectionEnd := func(name string) uint64 {
+		if o := getOutputWriterByName(ctx, name); o != nil {
+			return o.GetShdr().Addr + o.GetShdr().Size
+		}
+		return 0
+	}
+
+	for _, sym := range ctx.InternalObj.Symbols[1:] {
+		switch sym.Name {
+		case "__ehdr_start", "__executable_start":
+			sym.SetValue(ctx.OutputEhdrWriter.Shdr.Addr)
+		case "_etext", "etext":
+			sym.SetValue(textEnd)
+		case "_edata", "edata":
+			sym.SetValue(dataEnd)
+		case "_end", "end":
+			sym.SetValue(end)
+		case "__bss_start":
+			sym.SetValue(bssStart)
+		case "__global_pointer$":
+			sym.SetValue(gp)
+		case "__init_array_start":
+			sym.SetValue(sectionStart(".init_array"))
+		case "__init_array_end":
+			sym.SetValue(sectionEnd(".init_array"))
+		case "__fini_array_start":
+			sym.SetValue(sectionStart(".fini_array"))
+		case "__fini_array_end":
+			sym.SetValue(sectionEnd(".fini_array"))
+		case "__preinit_array_start":
+			sym.SetValue(sectionStart(".preinit_array"))
+		case "__preinit_array_end":
+			sym.SetValue(sectionEnd(".preinit_array"))
+		case "__rela_iplt_start":
+			sym.SetValue(sectionStart(".rela.iplt"))
+		case "__rela_iplt_end":
+			sym.SetValue(sectionEnd(".rela.iplt"))
+		case "_DYNAMIC":
+			sym.SetValue(sectionStart(".dynamic"))
+		case "_GLOBAL_OFFSET_TABLE_":
+			sym.SetValue(sectionStart(".got"))
+		default:
+			if strings.HasPrefix(sym.Name, "__start_") {
+				sym.SetValue(sectionStart(sym.Name[len("__start_"):]))
+			} else if strings.HasPrefix(sym.Name, "__stop_") {
+				sym.SetValue(sectionEnd(sym.Name[len("__stop_"):]))
+			}
+		}
+	}
+}
+
+// get called after SetOutputShdrOffsets
+// symbols inside discarded input .eh_frame now get their final address
+func FixEhFrameSymbols(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		for _, sym := range file.EhFrameSymbols {
+			if sym.File != file {
+				continue
+			}
+			if ctx.OutputEhFrameWriter == nil {
+				sym.SetValue(0)
+				continue
+			}
+			// value from the object file, this may run again after relaxation
+			sym.SetValue(ctx.OutputEhFrameWriter.GetSymbolAddr(ctx, file, sym.GetElfSym().Val))
+		}
+	}
+}
+
 func CreateSpecialWriters(ctx *Context) {
 	push := func(o iOutputWriter) iOutputWriter {
 		ctx.OutputWriters = append(ctx.OutputWriters, o)
@@ -73,20 +633,119 @@ func CreateSpecialWriters(ctx *Context) {
 	}
 	ctx.OutputEhdrWriter = push(NewOutputEhdrWriter()).(*OutputEhdrWriter)
 	ctx.OutputPhdrsWriter = push(NewOutputPhdrsWriter()).(*OutputPhdrsWriter)
-	//ctx.OutputShdrsWriter = push(NewOutputShdrsWriter()).(*OutputShdrsWriter)
+	ctx.OutputShdrsWriter = push(NewOutputShdrsWriter()).(*OutputShdrsWriter)
 	ctx.OutputGotSectionWriter = push(NewOutputGotSectionWriter()).(*OutputGotSectionWriter)
+
+	// the dynamic loader only needs these if shared objects are linked
+	// or the output is position independent
+	if ctx.IsDynamic() {
+		if !ctx.Args.Shared && !ctx.IsStaticPie() && !ctx.Args.NoDynamicLinker {
+			ctx.OutputInterpWriter = push(NewOutputInterpWriter()).(*OutputInterpWriter)
+		}
+		ctx.OutputDynamicWriter = push(NewOutputDynamicWriter()).(*OutputDynamicWriter)
+		ctx.OutputDynsymWriter = push(NewOutputDynsymWriter()).(*OutputDynsymWriter)
+		ctx.OutputDynstrWriter = push(NewOutputDynstrWriter()).(*OutputStrtabWriter)
+		if ctx.Args.HashStyle != "gnu" {
+			ctx.OutputHashWriter = push(NewOutputHashWriter()).(*OutputHashWriter)
+		}
+		if ctx.Args.HashStyle != "sysv" {
+			ctx.OutputGnuHashWriter = push(NewOutputGnuHashWriter()).(*OutputGnuHashWriter)
+		}
+		ctx.OutputRelaDynWriter = push(NewOutputRelaDynWriter()).(*OutputRelaDynWriter)
+		if ctx.Args.PackRelativeRelocs {
+			ctx.OutputRelrDynWriter = push(NewOutputRelrDynWriter()).(*OutputRelrDynWriter)
+		}
+		ctx.OutputRelaPltWriter = push(NewOutputRelaPltWriter()).(*OutputRelaPltWriter)
+		ctx.OutputPltWriter = push(NewOutputPltWriter()).(*OutputPltWriter)
+		ctx.OutputGotPltWriter = push(NewOutputGotPltWriter()).(*OutputGotPltWriter)
+	}
+
+	hasEhFrame := false
+	for _, file := range ctx.Args.ObjFiles {
+		if len(file.Cies) > 0 {
+			hasEhFrame = true
+		}
+	}
+	if hasEhFrame {
+		ctx.OutputEhFrameWriter = push(NewOutputEhFrameWriter()).(*OutputEhFrameWriter)
+		if ctx.Args.EhFrameHdr {
+			ctx.OutputEhFrameHdrWriter = push(NewOutputEhFrameHdrWriter()).(*OutputEhFrameHdrWriter)
+		}
+	}
+
+	ctx.OutputSymtabWriter = push(NewOutputSymtabWriter()).(*OutputSymtabWriter)
+	ctx.OutputStrtabWriter = push(NewOutputStrtabWriter()).(*OutputStrtabWriter)
+	ctx.OutputShStrtabWriter = push(NewOutputShStrtabWriter()).(*OutputShStrtabWriter)
+}
+
+// the size of .relr.dyn depends on the addresses it relocates
+// returns true if the layout has to be done again
+func UpdateRelrDynSize(ctx *Context) bool {
+	r := ctx.OutputRelrDynWriter
+	if r == nil {
+		return false
+	}
+	size := r.Shdr.Size
+	r.UpdateSize(ctx)
+	return r.Shdr.Size != size
+}
+
+// ehdr, phdr and shdr are headers instead of sections, so they keep shndx 0
+// should be called after the writers are sorted
+func SetOutputWriterShndxs(ctx *Context) {
+	shndx := int64(1)
+	for _, o := range ctx.OutputWriters {
+		if o == ctx.OutputEhdrWriter || o == ctx.OutputPhdrsWriter ||
+			o == ctx.OutputShdrsWriter {
+			continue
+		}
+		o.SetShndx(shndx)
+		shndx++
+	}
+}
+
+// a position independent output is linked at zero, the dynamic loader
+// picks the address
+func getImageBase(ctx *Context) uint64 {
+	if ctx.IsPic() {
+		return 0
+	}
+	return ADDR_BASE
 }
 
 // get called after CreateSpecialWriters,
 // since OutputWriters have to be filled
 // size and align are calculated in previous steps
 func SetOutputShdrOffsets(ctx *Context) uint64 {
-	addr := ADDR_BASE
+	// tp points to the start of the tls block (variant I), and the runtime
+	// aligns it to the alignment of PT_TLS, so the block has to start there too
+	tlsAlign := uint64(1)
+	for _, o := range ctx.OutputWriters {
+		if isTLS(o) {
+			tlsAlign = max(tlsAlign, o.GetShdr().AddrAlign)
+		}
+	}
+
+	addr := getImageBase(ctx)
+	firstTls := true
+	inRelro := false
 	for _, o := range ctx.OutputWriters {
 		if o.GetShdr().Flags&uint64(elf.SHF_ALLOC) == 0 {
 			continue
 		}
 
+		// the dynamic loader makes whole pages read-only, what follows
+		// relro starts at a new page
+		if isRELRO(ctx, o) {
+			inRelro = true
+		} else if inRelro {
+			addr = utils.AlignTo(addr, PageSize)
+			inRelro = false
+		}
+		if isTLS(o) && firstTls {
+			addr = utils.AlignTo(addr, tlsAlign)
+			firstTls = false
+		}
 		addr = utils.AlignTo(addr, o.GetShdr().AddrAlign)
 		o.GetShdr().Addr = addr
 
@@ -172,22 +831,31 @@ func SortOutputWriters(ctx *Context) {
 		typ := o.GetShdr().Type
 		flags := o.GetShdr().Flags
 
-		// non-allocs are behind, such as relocation, symbol table
-		if flags&uint64(elf.SHF_ALLOC) == 0 {
-			return math.MaxInt32 - 1
-		}
 		if o == ctx.OutputShdrsWriter {
 			return math.MaxInt32
 		}
+		// symbol tables go right before shdr, like what gnu ld does
+		if o == ctx.OutputSymtabWriter || o == ctx.OutputStrtabWriter ||
+			o == ctx.OutputShStrtabWriter {
+			return math.MaxInt32 - 1
+		}
+		// non-allocs are behind, such as relocation, symbol table
+		if flags&uint64(elf.SHF_ALLOC) == 0 {
+			return math.MaxInt32 - 2
+		}
 		if o == ctx.OutputPhdrsWriter {
 			return 1
 		}
 		if o == ctx.OutputEhdrWriter {
 			return 0
 		}
-		if typ == uint32(elf.SHT_NOTE) {
+		// the dynamic loader path is read right after the headers
+		if o == ctx.OutputInterpWriter {
 			return 2
 		}
+		if typ == uint32(elf.SHT_NOTE) {
+			return 3
+		}
 
 		toBit := func(b bool) int {
 			if b {
@@ -196,13 +864,15 @@ func SortOutputWriters(ctx *Context) {
 			return 0
 		}
 
-		// non-writable first
+		// non-writable first, relro at the start of the writable ones
+		// so that they are in the same pages
 		writeable := toBit(flags&uint64(elf.SHF_WRITE) != 0)
 		notExec := toBit(flags&uint64(elf.SHF_EXECINSTR) == 0)
+		notRelro := toBit(!isRELRO(ctx, o))
 		notTls := toBit(flags&uint64(elf.SHF_TLS) == 0)
 		isBss := toBit(typ == uint32(elf.SHT_NOBITS))
 
-		return int32(writeable<<7 | notExec<<6 | notTls<<5 | isBss<<4)
+		return int32(writeable<<7 | notExec<<6 | notRelro<<5 | notTls<<4 | isBss<<3)
 	}
 
 	// same values' order remain the same
@@ -220,13 +890,284 @@ func UpdateFragmentOffsetAndMergedSectionSizeAlign(ctx *Context) {
 
 func ScanRelsAndAddSymsToGot(ctx *Context) {
 	for _, file := range ctx.Args.ObjFiles {
-		file.ScanRelsFindGotSyms()
+		file.ScanRelsFindGotSyms(ctx)
 	}
+	// undefined weak symbols have no file but still need an entry (zero)
+	added := make(map[*Symbol]bool)
 	for _, file := range ctx.Args.ObjFiles {
 		for _, sym := range file.Symbols {
-			if sym.File == file && (sym.Flags&IsInGot) != 0 {
-				ctx.OutputGotSectionWriter.AddGotTLSSym(sym)
+			if (sym.File != file && sym.File != nil) || added[sym] {
+				continue
+			}
+			added[sym] = true
+			if sym.Flags&NeedsGot != 0 {
+				ctx.OutputGotSectionWriter.AddGotSym(sym)
+			}
+			if sym.Flags&NeedsGotTp != 0 {
+				ctx.OutputGotSectionWriter.AddGotTpSym(sym)
+			}
+			if sym.Flags&NeedsTlsGd != 0 {
+				ctx.OutputGotSectionWriter.AddTlsGdSym(sym)
+			}
+			if sym.Flags&NeedsTlsDesc != 0 {
+				ctx.OutputGotSectionWriter.AddTlsDescSym(sym)
+			}
+			// aliases share the copy of the first one
+			if sym.Flags&NeedsCopyrel != 0 && sym.InputSection == nil {
+				addCopyrelSymbol(ctx, sym)
+			}
+			if sym.Flags&NeedsPlt == 0 {
+				continue
+			}
+			if !sym.usesIplt(ctx) {
+				ctx.OutputPltWriter.AddSym(sym)
+				continue
+			}
+			// .iplt only exists if an ifunc is used, the dynamic loader
+			// applies the IRELATIVEs in .rela.dyn if there is one
+			if ctx.OutputIpltWriter == nil {
+				ctx.OutputIpltWriter = NewOutputIpltWriter()
+				ctx.OutputWriters = append(ctx.OutputWriters, ctx.OutputIpltWriter)
+				if !ctx.IsDynamic() {
+					ctx.OutputRelaIpltWriter = NewOutputRelaIpltWriter()
+					ctx.OutputWriters = append(ctx.OutputWriters, ctx.OutputRelaIpltWriter)
+				}
 			}
+			ctx.OutputGotSectionWriter.AddGotPltSym(sym)
+			ctx.OutputIpltWriter.AddSym(sym)
 		}
 	}
 }
+
+// data of a shared object used by the code of the output is copied into
+// .bss, like commons the section is owned by the internal file
+// the copy is made by the dynamic loader (R_RISCV_COPY), and the shared
+// object uses it instead of its own
+func addCopyrelSymbol(ctx *Context, sym *Symbol) {
+	if ctx.CopyrelSection == nil {
+		obj := ctx.InternalObj
+		shdr := &Shdr{
+			Type:      uint32(elf.SHT_NOBITS),
+			Flags:     uint64(elf.SHF_ALLOC | elf.SHF_WRITE),
+			AddrAlign: 1,
+		}
+		isec := NewInputSection(obj, nil, uint32(len(obj.InputSections)), shdr, ".bss")
+		isec.P2Align = 0
+		isec.SetInputSectionOutputSection(isec.GetInputSectionOutputSection(ctx))
+		isec.OutputSection.InputSections = append(isec.OutputSection.InputSections, isec)
+		obj.InputSections = append(obj.InputSections, isec)
+		ctx.CopyrelSection = isec
+	}
+
+	isec := ctx.CopyrelSection
+	file := sym.File
+	esym := sym.GetElfSym()
+	align := file.GetDsoSymbolAlign(sym.SymIdx)
+	offset := utils.AlignTo(isec.Shdr.Size, align)
+	isec.Shdr.Size = offset + esym.Size
+	isec.Shdr.AddrAlign = max(isec.Shdr.AddrAlign, align)
+	isec.SetInputSectionSize(isec.Shdr.Size)
+	isec.SetP2Align(isec.Shdr.AddrAlign)
+
+	for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+		alias := file.Symbols[i]
+		other := &file.ElfSyms[i]
+		if alias.File != file || other.IsUndef() || other.Val != esym.Val {
+			continue
+		}
+		alias.Flags |= NeedsCopyrel | NeedsDynsym
+		alias.SetInputSection(isec)
+		alias.SetValue(offset)
+	}
+	ctx.OutputRelaDynWriter.CopyrelSyms = append(ctx.OutputRelaDynWriter.CopyrelSyms, sym)
+}
+
+// --as-needed, a shared object is only kept (and gets a DT_NEEDED)
+// if a regular object references one of its symbols
+// should be called after symbols are resolved, they are resolved again
+// without the removed ones
+func RemoveUnneededSharedFiles(ctx *Context) {
+	needed := make(map[*ObjectFile]bool)
+	for _, file := range ctx.Args.ObjFiles {
+		if file.IsDso {
+			continue
+		}
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			esym := &file.ElfSyms[i]
+			sym := file.Symbols[i]
+			if esym.IsUndef() && esym.Bind() != uint8(elf.STB_WEAK) && sym.File != nil {
+				needed[sym.File] = true
+			}
+		}
+	}
+
+	removed := false
+	for _, file := range ctx.Args.ObjFiles {
+		if file.IsDso && file.AsNeeded && !needed[file] {
+			file.IsAlive = false
+			removed = true
+		}
+	}
+	if removed {
+		ClearUnusedFiles(ctx)
+		ResolveSymbols(ctx)
+	}
+}
+
+// global symbols defined by the output, not hidden
+// an executable only exports them if shared objects use them (or
+// --export-dynamic), a shared object exports all of them
+func isExportable(sym *Symbol) bool {
+	if sym.File == nil || sym.IsImported() {
+		return false
+	}
+	esym := sym.GetElfSym()
+	vis := sym.Visibility
+	return !esym.IsUndef() && esym.Bind() != uint8(elf.STB_LOCAL) &&
+		(vis == uint8(elf.STV_DEFAULT) || vis == uint8(elf.STV_PROTECTED)) &&
+		sym.VerIdx != VER_NDX_LOCAL
+}
+
+// decides the version of each symbol defined by the output, from
+// foo@@VER and foo@VER names or else the --version-script patterns
+// symbols made local by the script are not exported
+func ApplyVersionScript(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		if file.IsDso || !file.IsAlive {
+			continue
+		}
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			sym := file.Symbols[i]
+			if sym.File != file || file.ElfSyms[i].IsUndef() {
+				continue
+			}
+			if ver := file.SymVers[i]; ver != "" {
+				sym.VerIdx = ctx.GetVersionIdx(ver)
+				if sym.VerIdx == 0 {
+					utils.Fatal(file.GetFileName() + ": symbol " + sym.Name +
+						" has undefined version " + ver)
+				}
+				continue
+			}
+			if idx, ok := ctx.FindSymbolVersion(sym.Name); ok {
+				sym.VerIdx = idx
+			}
+		}
+	}
+}
+
+// .gnu.version is only needed if the output defines versions, or uses
+// versioned symbols of shared objects
+// should be called after the dynamic symbols are created
+func CreateVersionSections(ctx *Context) {
+	if !ctx.IsDynamic() {
+		return
+	}
+
+	verneed := NewOutputVerneedWriter(ctx)
+	for _, sym := range ctx.OutputDynsymWriter.Symbols[1:] {
+		if sym.IsImported() {
+			if ver := sym.File.GetSymbolVersion(sym.SymIdx); ver > VER_NDX_GLOBAL {
+				verneed.AddVersion(ctx, sym.File, ver)
+			}
+		}
+	}
+	// glibc refuses .relr.dyn unless the output asks for this version
+	if ctx.OutputRelrDynWriter != nil {
+		for _, file := range ctx.Args.ObjFiles {
+			for ver, name := range file.VerNames {
+				if file.IsDso && name == "GLIBC_ABI_DT_RELR" {
+					verneed.AddVersion(ctx, file, uint16(ver))
+				}
+			}
+		}
+	}
+
+	if len(verneed.Needs) == 0 && len(ctx.VersionNames) == 0 {
+		return
+	}
+	push := func(o iOutputWriter) iOutputWriter {
+		ctx.OutputWriters = append(ctx.OutputWriters, o)
+		return o
+	}
+	ctx.OutputVersymWriter = push(NewOutputVersymWriter()).(*OutputVersymWriter)
+	if len(ctx.VersionNames) > 0 {
+		ctx.OutputVerdefWriter = push(NewOutputVerdefWriter(ctx)).(*OutputVerdefWriter)
+	}
+	if len(verneed.Needs) > 0 {
+		ctx.OutputVerneedWriter = push(verneed).(*OutputVerneedWriter)
+	}
+}
+
+// undefined symbols of shared objects, an executable exports them
+// if it defines them
+func getDsoReferences(ctx *Context) map[*Symbol]bool {
+	referenced := make(map[*Symbol]bool)
+	for _, file := range ctx.Args.ObjFiles {
+		if !file.IsDso {
+			continue
+		}
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			if file.ElfSyms[i].IsUndef() {
+				referenced[file.Symbols[i]] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// .dynsym gets the symbols of shared objects used by the output, and the
+// symbols of the output used by shared objects
+// should be called after relocations are scanned, imported symbols go
+// first and the others are sorted by their .gnu.hash bucket
+func CreateDynamicSymbols(ctx *Context) {
+	if !ctx.IsDynamic() {
+		return
+	}
+
+	referenced := getDsoReferences(ctx)
+
+	// undefined symbols of a shared object, weak if every reference is
+	strong := make(map[*Symbol]bool)
+	syms := make([]*Symbol, 0)
+	added := make(map[*Symbol]bool)
+	for _, file := range ctx.Args.ObjFiles {
+		for i := file.FirstGlobal; i < file.TotalSyms; i++ {
+			sym := file.Symbols[i]
+			if sym.File == nil && file.ElfSyms[i].Bind() != uint8(elf.STB_WEAK) {
+				strong[sym] = true
+			}
+			if (sym.File != file && sym.File != nil) || added[sym] {
+				continue
+			}
+			if sym.Flags&NeedsDynsym != 0 || (isExportable(sym) &&
+				(ctx.Args.ExportDynamic || ctx.Args.Shared || referenced[sym])) {
+				added[sym] = true
+				syms = append(syms, sym)
+			}
+		}
+	}
+	for _, sym := range syms {
+		if sym.File == nil && !strong[sym] {
+			ctx.OutputDynsymWriter.WeakUndefs[sym] = true
+		}
+	}
+
+	ctx.OutputDynamicWriter.AddStrings(ctx)
+	hashed := make([]*Symbol, 0)
+	for _, sym := range syms {
+		if isDynsymHashed(sym) {
+			hashed = append(hashed, sym)
+		} else {
+			ctx.OutputDynsymWriter.AddSymbol(ctx, sym)
+		}
+	}
+
+	nbuckets := getGnuHashBuckets(len(hashed))
+	sort.SliceStable(hashed, func(i, j int) bool {
+		return gnuHash(hashed[i].GetDynName())%nbuckets < gnuHash(hashed[j].GetDynName())%nbuckets
+	})
+	for _, sym := range hashed {
+		ctx.OutputDynsymWriter.AddSymbol(ctx, sym)
+	}
+}
@@ -0,0 +1,336 @@
+package linker
+
+import (
+	"debug/elf"
+	"fmt"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"sort"
+)
+
+// bytes removed from an input section by relaxation,
+// a negative size means bytes are inserted (compressed instructions and
+// far branches widened)
+// offset is in the original content, total counts every change up to this one
+type RelaxDelta struct {
+	Offset uint64
+	Size   int64
+	Total  int64
+}
+
+func isInt(val uint64, bits int) bool {
+	return utils.SignExtend(val, bits-1) == val
+}
+
+func isRvcReloc(typ uint32) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_RVC_BRANCH, elf.R_RISCV_RVC_JUMP, elf.R_RISCV_RVC_LUI:
+		return true
+	}
+	return false
+}
+
+// whether the compressed instruction can hold the value
+func rvcFits(typ uint32, target, P uint64) bool {
+	switch elf.R_RISCV(typ) {
+	case elf.R_RISCV_RVC_BRANCH:
+		return isInt(target-P, 9)
+	case elf.R_RISCV_RVC_JUMP:
+		return isInt(target-P, 12)
+	case elf.R_RISCV_RVC_LUI:
+		// c.lui with zero is reserved
+		hi := uint64(int64(target+0x800) >> 12)
+		return hi != 0 && isInt(hi, 6)
+	}
+	return true
+}
+
+// instructions are relaxed only if the assembler marked them with R_RISCV_RELAX
+func hasRelaxMark(rels []Rela, idx int) bool {
+	return idx+1 < len(rels) &&
+		rels[idx+1].Type == uint32(elf.R_RISCV_RELAX) &&
+		rels[idx+1].Offset == rels[idx].Offset
+}
+
+func (i *InputSection) IsRelaxable() bool {
+	return i.IsAlive && i.RelSecIdx != 0 &&
+		i.Shdr.Flags&uint64(elf.SHF_ALLOC) != 0 &&
+		i.Shdr.Flags&uint64(elf.SHF_EXECINSTR) != 0
+}
+
+// maps an offset of the original content to the offset after bytes are removed
+// or inserted
+func (i *InputSection) GetRelaxedOffset(offset uint64) uint64 {
+	deltas := i.RelaxDeltas
+	// inserted bytes follow the widened instruction, so they move
+	// the offset they are inserted at, removed bytes don't
+	idx := sort.Search(len(deltas), func(idx int) bool {
+		d := deltas[idx]
+		return d.Offset > offset || (d.Offset == offset && d.Size > 0)
+	})
+	if idx == 0 {
+		return offset
+	}
+
+	d := deltas[idx-1]
+	// inside the removed bytes, points to where they used to start
+	if d.Size > 0 && offset < d.Offset+uint64(d.Size) {
+		return uint64(int64(d.Offset) - (d.Total - d.Size))
+	}
+	return uint64(int64(offset) - d.Total)
+}
+
+// section symbols point to the start of the section and the addend is
+// an offset inside the original content, it has to follow the deletions
+func (f *ObjectFile) GetRelAddend(rel *Rela) uint64 {
+	sym := f.Symbols[rel.Sym]
+	if sym.InputSection == nil || len(sym.InputSection.RelaxDeltas) == 0 {
+		return uint64(rel.Addend)
+	}
+	if f.ElfSyms[rel.Sym].Type() != uint8(elf.STT_SECTION) {
+		return uint64(rel.Addend)
+	}
+	return sym.InputSection.GetRelaxedOffset(uint64(rel.Addend))
+}
+
+// decides which instructions can be shortened with the current addresses
+// compressed instructions and branches that cannot reach their target are widened
+// bytes removed once stay removed, a target that moves out of reach later
+// goes through the widened form or a thunk, so the layout converges
+// returns true if the removed bytes are different from the last time
+func (i *InputSection) Relax(ctx *Context) bool {
+	rels := i.GetRels()
+	rvc := i.ObjFile.ElfEhdr.Flags&EF_RISCV_RVC != 0
+
+	var gp uint64
+	hasGp := false
+	// absolute addresses of a position independent output are not at
+	// a fixed distance from gp
+	if sym, ok := ctx.SymbolMap["__global_pointer$"]; ok && sym.File != nil && !ctx.IsPic() {
+		gp = sym.GetAddr()
+		hasGp = true
+	}
+
+	deltas := make([]RelaxDelta, 0)
+	removed := make([]int64, len(rels))
+	total := int64(0)
+
+	for idx := range rels {
+		rel := &rels[idx]
+		// where the relocation ends up after the removals before it
+		P := uint64(int64(i.GetAddr()+rel.Offset) - total)
+		// what the last round did with this relocation
+		prev := int64(0)
+		if len(i.RelaxRemoved) > idx {
+			prev = i.RelaxRemoved[idx]
+		}
+
+		start := rel.Offset
+		size := int64(0)
+
+		if isRvcReloc(rel.Type) {
+			// widened instructions stay widened, so the layout converges
+			// this is done even with --no-relax, like R_RISCV_ALIGN
+			if i.ObjFile.Symbols[rel.Sym].File == nil {
+				continue
+			}
+			S, A := i.ObjFile.GetRelSymbolAddrAndAddend(ctx, rel)
+			if prev < 0 || !rvcFits(rel.Type, S+A, P) {
+				start, size = rel.Offset+2, -2
+			}
+		} else if rel.Type == uint32(elf.R_RISCV_BRANCH) {
+			// a branch that cannot reach its target becomes an inverted
+			// branch over a jal, which reaches ±1MiB (or a thunk)
+			// it stays widened too, this is not relaxation either
+			if i.ObjFile.Symbols[rel.Sym].File == nil {
+				continue
+			}
+			S, A := i.ObjFile.GetRelSymbolAddrAndAddend(ctx, rel)
+			if prev < 0 || !isInt(S+A-P, 13) {
+				start, size = rel.Offset+4, -4
+			}
+		} else if rel.Type == uint32(elf.R_RISCV_ALIGN) {
+			// the assembler reserved addend bytes of nops, only keep what
+			// is needed to reach the alignment
+			// this is done even with --no-relax, otherwise code is misaligned
+			A := uint64(rel.Addend)
+			padding := utils.AlignTo(P, utils.BitCeil(A+1)) - P
+			if padding > A {
+				utils.Fatal(i.ObjFile.GetFileName() + ": " + i.Name +
+					": R_RISCV_ALIGN needs more padding than reserved")
+			}
+			start = rel.Offset + padding
+			size = int64(A - padding)
+		} else if ctx.Args.Relax && hasRelaxMark(rels, idx) {
+			sym := i.ObjFile.Symbols[rel.Sym]
+			if sym.File == nil {
+				continue
+			}
+			S, A := i.ObjFile.GetRelSymbolAddrAndAddend(ctx, rel)
+
+			switch elf.R_RISCV(rel.Type) {
+			case elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT:
+				// auipc + jalr => c.j or jal
+				// c.j that cannot reach anymore becomes jal, and jal
+				// that cannot reach goes through a thunk (see CreateThunks)
+				dist := S + A - P
+				rd := utils.Bits(utils.ReadWithReturn[uint32](i.Content[rel.Offset+4:]), 11, 7)
+				if prev != 4 && rvc && rd == 0 && isInt(dist, 12) {
+					start, size = rel.Offset+2, 6
+				} else if prev != 0 || isInt(dist, 21) {
+					start, size = rel.Offset+4, 4
+				}
+			case elf.R_RISCV_HI20:
+				// lui is not needed if lo12 alone can reach the symbol,
+				// from zero or from gp, otherwise try c.lui
+				// a removed lui can only become c.lui
+				val := S + A
+				rd := utils.Bits(utils.ReadWithReturn[uint32](i.Content[rel.Offset:]), 11, 7)
+				hi := uint64(int64(val+0x800) >> 12)
+				if prev != 2 && (isInt(val, 12) || (hasGp && isInt(val-gp, 12))) {
+					size = 4
+				} else if rvc && rd != 0 && rd != 2 && hi != 0 && isInt(hi, 6) {
+					start, size = rel.Offset+2, 2
+				} else if prev != 0 {
+					utils.Fatal(fmt.Sprintf("%s: relaxed lui cannot reach %s anymore",
+						i.getRelLocation(rel), i.getRelSymbolName(rel)))
+				}
+			case elf.R_RISCV_TPREL_HI20, elf.R_RISCV_TPREL_ADD:
+				// lui and add are not needed if lo12 can reach the symbol from tp
+				if isInt(S+A-ctx.TLSSegmentAddr, 12) {
+					size = 4
+				} else if prev != 0 {
+					utils.Fatal(fmt.Sprintf("%s: relaxed lui cannot reach %s anymore",
+						i.getRelLocation(rel), i.getRelSymbolName(rel)))
+				}
+			}
+		}
+
+		if size == 0 {
+			continue
+		}
+		total += size
+		removed[idx] = size
+		deltas = append(deltas, RelaxDelta{Offset: start, Size: size, Total: total})
+	}
+
+	changed := len(deltas) != len(i.RelaxDeltas)
+	for idx := 0; !changed && idx < len(deltas); idx++ {
+		changed = deltas[idx] != i.RelaxDeltas[idx]
+	}
+
+	i.RelaxDeltas = deltas
+	i.RelaxRemoved = removed
+	i.SecSize = uint64(int64(i.Shdr.Size) - total)
+	return changed
+}
+
+// writes the content without the removed bytes
+// inserted bytes are left for the widened instruction
+func (i *InputSection) copyRelaxedContent(buf []byte) {
+	prev := uint64(0)
+	out := uint64(0)
+	for _, d := range i.RelaxDeltas {
+		out += uint64(copy(buf[out:], i.Content[prev:d.Offset]))
+		if d.Size > 0 {
+			prev = d.Offset + uint64(d.Size)
+		} else {
+			out += uint64(-d.Size)
+			prev = d.Offset
+		}
+	}
+	copy(buf[out:], i.Content[prev:])
+}
+
+// rewrites the instructions that were shortened or widened, loc points to
+// the relocation after the removed bytes are taken out
+func (i *InputSection) writeRelaxedInstr(rels []Rela, idx int, loc []byte, target, P uint64) {
+	rel := &rels[idx]
+	val := target - P
+	switch elf.R_RISCV(rel.Type) {
+	case elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT:
+		rd := utils.Bits(utils.ReadWithReturn[uint32](i.Content[rel.Offset+4:]), 11, 7)
+		if i.RelaxRemoved[idx] == 6 {
+			// c.j offset
+			i.checkRange(rel, val, -(1 << 11), 1<<11)
+			utils.Write[uint16](loc, 0xa001|cjtype(uint16(val)))
+		} else {
+			// jal rd, offset
+			i.checkRange(rel, val, -(1 << 20), 1<<20)
+			utils.Write[uint32](loc, 0x6f|rd<<7|jtype(uint32(val)))
+		}
+	case elf.R_RISCV_HI20:
+		if i.RelaxRemoved[idx] == 2 {
+			// c.lui rd, hi
+			rd := utils.Bits(utils.ReadWithReturn[uint32](i.Content[rel.Offset:]), 11, 7)
+			utils.Write[uint16](loc, 0x6001|uint16(rd)<<7)
+			writeCLUItype(loc, uint16((target+0x800)>>12))
+		}
+	case elf.R_RISCV_BRANCH:
+		// b<cond> rs1, rs2, offset => b<!cond> rs1, rs2, 8; jal x0, offset
+		// flipping the lowest bit of funct3 inverts the condition
+		instr := utils.ReadWithReturn[uint32](i.Content[rel.Offset:])
+		utils.Write[uint32](loc, (instr^1<<12)&0b0000000_11111_11111_111_00000_1111111|btype(8))
+		i.checkRange(rel, val-4, -(1 << 20), 1<<20)
+		utils.Write[uint32](loc[4:], 0x6f|jtype(uint32(val-4)))
+	case elf.R_RISCV_RVC_BRANCH:
+		// c.beqz rs1', offset => beq rs1, x0, offset
+		// c.bnez rs1', offset => bne rs1, x0, offset
+		i.checkRange(rel, val, -(1 << 12), 1<<12)
+		instr := utils.ReadWithReturn[uint16](i.Content[rel.Offset:])
+		rs1 := uint32(utils.Bits(instr, 9, 7)) + 8
+		funct3 := uint32(utils.Bits(instr, 15, 13)) - 0b110
+		utils.Write[uint32](loc, 0x63|funct3<<12|rs1<<15|btype(uint32(val)))
+	case elf.R_RISCV_RVC_JUMP:
+		// c.j offset => jal x0, offset
+		i.checkRange(rel, val, -(1 << 20), 1<<20)
+		utils.Write[uint32](loc, 0x6f|jtype(uint32(val)))
+	case elf.R_RISCV_RVC_LUI:
+		// c.lui rd, hi => lui rd, hi
+		i.checkHi20Range(rel, target)
+		rd := uint32(utils.Bits(utils.ReadWithReturn[uint16](i.Content[rel.Offset:]), 11, 7))
+		utils.Write[uint32](loc, 0x37|rd<<7|utype(uint32(target)))
+	case elf.R_RISCV_ALIGN:
+		// fill what is left of the padding with nops
+		padding := uint64(rel.Addend) - uint64(i.RelaxRemoved[idx])
+		for off := uint64(0); off+4 <= padding; off += 4 {
+			utils.Write[uint32](loc[off:], 0x00000013)
+		}
+		if padding%4 != 0 {
+			utils.Write[uint16](loc[padding-2:], 0x0001)
+		}
+	}
+}
+
+// symbols keep the value from the object file and follow the deletions
+func updateRelaxedSymbols(ctx *Context) {
+	for _, file := range ctx.Args.ObjFiles {
+		for _, sym := range file.Symbols {
+			if sym.File == nil || sym.InputSection == nil ||
+				!sym.InputSection.IsRelaxable() {
+				continue
+			}
+			sym.SetValue(sym.InputSection.GetRelaxedOffset(sym.GetElfSym().Val))
+		}
+	}
+}
+
+// one round of relaxation over every executable section
+// returns true if the layout has to be done again
+func RelaxSections(ctx *Context) bool {
+	changed := false
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsRelaxable() {
+				continue
+			}
+			if isec.Relax(ctx) {
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		updateRelaxedSymbols(ctx)
+	}
+	return changed
+}
@@ -9,9 +9,11 @@ type SectionFragment struct {
 	IsAlive bool
 }
 
+// alive unless --gc-sections finds nothing using it
 func NewSectionFragment() *SectionFragment {
 	return &SectionFragment {
-		Offset: math.MaxUint32,
+		Offset:  math.MaxUint32,
+		IsAlive: true,
 	}
 }
 
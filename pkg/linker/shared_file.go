@@ -0,0 +1,117 @@
+package linker
+
+import (
+	"debug/elf"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"path/filepath"
+)
+
+// a shared object only provides symbols, its sections are not copied
+// the symbols come from .dynsym, and the name recorded in DT_NEEDED is
+// DT_SONAME (or the file name without one)
+func NewSharedFile(file *File, asNeeded bool, ctx *Context) {
+	f := ObjectFile{
+		File:       file,
+		ElfSecHdrs: []Shdr{},
+		IsAlive:    true,
+		IsDso:      true,
+		AsNeeded:   asNeeded,
+	}
+
+	f.ParseHeaders()
+	f.Soname = filepath.Base(file.Name)
+	if shdr := f.FindSectionHdr(uint32(elf.SHT_DYNAMIC)); shdr != nil {
+		strTab := f.GetBytesFromIdx(shdr.Link)
+		for _, dyn := range utils.ReadSlice[Dyn](f.GetBytesFromShdr(shdr), DynSize) {
+			if dyn.Tag == int64(elf.DT_SONAME) {
+				f.Soname = ElfGetName(strTab, uint32(dyn.Val))
+			}
+		}
+	}
+
+	// e.g. libc.so.6 named by both the command line and a linker script
+	for _, other := range ctx.Args.ObjFiles {
+		if other.IsDso && other.Soname == f.Soname {
+			return
+		}
+	}
+
+	f.ParseVersions()
+	f.SymTabSecHdr = f.FindSectionHdr(uint32(elf.SHT_DYNSYM))
+	if f.SymTabSecHdr != nil {
+		f.FirstGlobal = f.SymTabSecHdr.Info
+		f.SymStrTab = f.GetBytesFromIdx(f.SymTabSecHdr.Link)
+		f.FillInElfSymsAndSymbols(ctx, f.SymTabSecHdr)
+		f.AddDefaultVersionAliases(ctx)
+	}
+	ctx.Args.ObjFiles = append(ctx.Args.ObjFiles, &f)
+}
+
+// the size of the copy is the size of the symbol, the alignment is
+// the largest power of two the address is a multiple of, up to the
+// alignment of its section
+func (f *ObjectFile) GetDsoSymbolAlign(idx uint32) uint64 {
+	esym := &f.ElfSyms[idx]
+	align := uint64(1)
+	if shndx := esym.GetShndx(f.SymtabShndxSec, idx); shndx < uint32(len(f.ElfSecHdrs)) {
+		align = max(f.ElfSecHdrs[shndx].AddrAlign, 1)
+	}
+	for align > 1 && esym.Val%align != 0 {
+		align /= 2
+	}
+	return align
+}
+
+// .gnu.version has a version index for each dynamic symbol, and
+// .gnu.version_d names the versions the shared object defines,
+// index 1 is the base version (the shared object itself)
+func (f *ObjectFile) ParseVersions() {
+	if shdr := f.FindSectionHdr(uint32(elf.SHT_GNU_VERSYM)); shdr != nil {
+		f.Versyms = utils.ReadSlice[uint16](f.GetBytesFromShdr(shdr), 2)
+	}
+	shdr := f.FindSectionHdr(uint32(elf.SHT_GNU_VERDEF))
+	if shdr == nil {
+		return
+	}
+
+	strTab := f.GetBytesFromIdx(shdr.Link)
+	content := f.GetBytesFromShdr(shdr)
+	offset := uint32(0)
+	for idx := uint32(0); idx < shdr.Info; idx++ {
+		vd := utils.ReadWithReturn[Verdef](content[offset:])
+		aux := utils.ReadWithReturn[Verdaux](content[offset+vd.Aux:])
+		for len(f.VerNames) <= int(vd.Ndx) {
+			f.VerNames = append(f.VerNames, "")
+		}
+		f.VerNames[vd.Ndx] = ElfGetName(strTab, aux.Name)
+		if vd.Next == 0 {
+			break
+		}
+		offset += vd.Next
+	}
+}
+
+// a reference to foo@VER binds to the default version foo@@VER too,
+// so the symbol is added once more with that name
+func (f *ObjectFile) AddDefaultVersionAliases(ctx *Context) {
+	total := f.TotalSyms
+	for idx := f.FirstGlobal; idx < total && int(idx) < len(f.Versyms); idx++ {
+		ver := f.Versyms[idx]
+		if f.ElfSyms[idx].IsUndef() || ver&VERSYM_HIDDEN != 0 || ver <= VER_NDX_GLOBAL ||
+			int(ver) >= len(f.VerNames) {
+			continue
+		}
+		f.ElfSyms = append(f.ElfSyms, f.ElfSyms[idx])
+		f.Versyms = append(f.Versyms, ver)
+		f.Symbols = append(f.Symbols, ctx.GetSymbol(f.Symbols[idx].Name+"@"+f.VerNames[ver]))
+		f.TotalSyms++
+	}
+}
+
+// the version of a symbol of the shared object, the index in VerNames
+func (f *ObjectFile) GetSymbolVersion(idx uint32) uint16 {
+	if int(idx) >= len(f.Versyms) {
+		return VER_NDX_GLOBAL
+	}
+	return f.Versyms[idx] &^ VERSYM_HIDDEN
+}
@@ -1,7 +1,20 @@
 package linker
 
+import (
+	"debug/elf"
+	"math"
+	"strings"
+)
+
 const (
-	IsInGot uint32 = 1 << 0
+	NeedsGot          uint32 = 1 << 0 // got entry with the address
+	NeedsGotTp        uint32 = 1 << 1 // got entry with the offset from tp
+	NeedsTlsGd        uint32 = 1 << 2 // got entries with module id and dtv offset
+	NeedsPlt          uint32 = 1 << 3 // plt stub and got slot, for an ifunc or a preemptible function
+	NeedsCopyrel      uint32 = 1 << 4 // data of a shared object copied into .bss
+	NeedsCanonicalPlt uint32 = 1 << 5 // the address is taken, the plt stub stands for the function
+	NeedsDynsym       uint32 = 1 << 6 // in .dynsym, imported or exported
+	NeedsTlsDesc      uint32 = 1 << 7 // got entries of a tls descriptor, in a shared object
 )
 
 type Symbol struct {
@@ -11,15 +24,32 @@ type Symbol struct {
 	Name            string
 	Value           uint64
 	SymIdx          uint32
-	GotEntryIdx     uint32
+	GotIdx          uint32
+	GotTpIdx        uint32
+	TlsGdIdx        uint32
+	TlsDescIdx      uint32
+	GotPltIdx       uint32
+	PltIdx          uint32
+	DynsymIdx       uint32
 	Flags           uint32
+	VerIdx          uint16 // version in the output, VER_NDX_LOCAL if not exported
+	Visibility      uint8  // the most constraining one of all references and definitions
 }
 
 func NewSymbol(file *ObjectFile, name string) *Symbol {
 	return &Symbol{
-		File: file,
-		Name: name,
+		File:   file,
+		Name:   name,
+		VerIdx: VER_NDX_GLOBAL,
+	}
+}
+
+// foo@VER is foo in .dynsym, the version is in .gnu.version
+func (s *Symbol) GetDynName() string {
+	if at := strings.IndexByte(s.Name, '@'); at >= 0 {
+		return s.Name[:at]
 	}
+	return s.Name
 }
 
 // either use fragment or input section
@@ -42,6 +72,61 @@ func (s *Symbol) SetSymIdx(idx uint32) {
 	s.SymIdx = idx
 }
 
+// the elf symbol in the defining file
+func (s *Symbol) GetElfSym() *Sym {
+	return &s.File.ElfSyms[s.SymIdx]
+}
+
+// rank of the current definition, undefined is the worst
+func (s *Symbol) GetRank() uint32 {
+	if s.File == nil {
+		return math.MaxUint32
+	}
+	return getSymbolRank(s.File, s.GetElfSym())
+}
+
+// the resolver is called at startup to pick the function
+func (s *Symbol) IsIfunc() bool {
+	return s.File != nil && s.GetElfSym().Type() == STT_GNU_IFUNC
+}
+
+// defined by a shared object, the address is only known at runtime
+// unless the data is copied (NeedsCopyrel) or the plt stands for it
+func (s *Symbol) IsImported() bool {
+	return s.File != nil && s.File.IsDso
+}
+
+// resolved by the dynamic loader, since another module may provide it
+// imported symbols (unless copied), and in a shared object undefined ones
+// and exported ones of default visibility
+func (s *Symbol) IsPreemptible(ctx *Context) bool {
+	if s.File == nil {
+		return ctx.Args.Shared
+	}
+	if s.IsImported() {
+		return s.Flags&NeedsCopyrel == 0
+	}
+	return ctx.Args.Shared && isExportable(s) &&
+		s.Visibility == uint8(elf.STV_DEFAULT)
+}
+
+// internal is the most constraining one, then hidden and protected
+func (s *Symbol) MergeVisibility(vis uint8) {
+	if vis == uint8(elf.STV_DEFAULT) {
+		return
+	}
+	if s.Visibility == uint8(elf.STV_DEFAULT) || vis < s.Visibility {
+		s.Visibility = vis
+	}
+}
+
+// ifuncs bound in the output have a stub in .iplt, the others are in .plt
+// a preemptible one (exported by a shared object) is bound by the dynamic
+// loader through a JUMP_SLOT, which calls the resolver itself
+func (s *Symbol) usesIplt(ctx *Context) bool {
+	return s.IsIfunc() && !s.IsPreemptible(ctx)
+}
+
 func (s *Symbol) GetAddr() uint64 {
 	if s.SectionFragment != nil {
 		return s.SectionFragment.GetAddr() + s.Value
@@ -52,6 +137,34 @@ func (s *Symbol) GetAddr() uint64 {
 	return s.Value
 }
 
-func (s *Symbol) GetGotEntryAddr(ctx *Context) uint64 {
-	return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.GotEntryIdx*8)
+func (s *Symbol) GetGotAddr(ctx *Context) uint64 {
+	return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.GotIdx*8)
+}
+
+func (s *Symbol) GetGotTpAddr(ctx *Context) uint64 {
+	return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.GotTpIdx*8)
+}
+
+func (s *Symbol) GetTlsGdAddr(ctx *Context) uint64 {
+	return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.TlsGdIdx*8)
+}
+
+func (s *Symbol) GetTlsDescAddr(ctx *Context) uint64 {
+	return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.TlsDescIdx*8)
+}
+
+// the got slot the plt stub jumps through, an ifunc resolves into .got
+// and a preemptible function is bound lazily in .got.plt
+func (s *Symbol) GetGotPltAddr(ctx *Context) uint64 {
+	if s.usesIplt(ctx) {
+		return ctx.OutputGotSectionWriter.Shdr.Addr + uint64(s.GotPltIdx*8)
+	}
+	return ctx.OutputGotPltWriter.Shdr.Addr + uint64((GotPltHdrEntries+s.PltIdx)*8)
+}
+
+func (s *Symbol) GetPltAddr(ctx *Context) uint64 {
+	if s.usesIplt(ctx) {
+		return ctx.OutputIpltWriter.Shdr.Addr + uint64(s.PltIdx*IpltEntrySize)
+	}
+	return ctx.OutputPltWriter.Shdr.Addr + PltHdrSize + uint64(s.PltIdx*PltEntrySize)
 }
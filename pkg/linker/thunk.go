@@ -0,0 +1,174 @@
+package linker
+
+import (
+	"debug/elf"
+	"fmt"
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+)
+
+// auipc t1, hi; jalr x0, lo(t1)
+const ThunkEntrySize = 8
+
+// the elf symbol of the internal file that thunk entries share
+const thunkSymIdx = 1
+
+// range-extension thunk
+// JAL that cannot reach its target jumps to an entry of the thunk placed
+// right after the caller section, which jumps to the target
+// far BRANCH is widened to an inverted branch over a jal first (see Relax),
+// and that jal uses a thunk if it cannot reach either, so does CALL relaxed
+// to jal whose target moved out of reach
+type Thunk struct {
+	InputSection *InputSection
+	Entries      []*ThunkEntry
+}
+
+type ThunkEntry struct {
+	Thunk  *Thunk
+	Offset uint64
+	Caller *InputSection
+	Rel    Rela    // the first relocation redirected here, gives the target
+	Sym    *Symbol // listed in .symtab
+}
+
+func (e *ThunkEntry) GetAddr() uint64 {
+	return e.Thunk.InputSection.GetAddr() + e.Offset
+}
+
+// the thunk is an input section of the internal file,
+// so it is laid out like any other section
+func NewThunk(ctx *Context, caller *InputSection) *Thunk {
+	obj := ctx.InternalObj
+	shdr := &Shdr{
+		Type:      uint32(elf.SHT_PROGBITS),
+		Flags:     uint64(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+		AddrAlign: 4,
+	}
+	isec := NewInputSection(obj, nil, uint32(len(obj.InputSections)), shdr, ".text.thunk")
+	isec.SetP2Align(shdr.AddrAlign)
+	isec.SetInputSectionOutputSection(caller.OutputSection)
+	obj.InputSections = append(obj.InputSections, isec)
+
+	t := &Thunk{InputSection: isec}
+	isec.Thunk = t
+
+	// right after the caller, so that branches can reach it
+	osec := caller.OutputSection
+	for idx, other := range osec.InputSections {
+		if other == caller {
+			osec.InputSections = append(osec.InputSections[:idx+1],
+				append([]*InputSection{isec}, osec.InputSections[idx+1:]...)...)
+			break
+		}
+	}
+	return t
+}
+
+// relocations to the same place share an entry
+func (t *Thunk) GetEntry(ctx *Context, caller *InputSection, rel *Rela) *ThunkEntry {
+	sym := caller.ObjFile.Symbols[rel.Sym]
+	for _, e := range t.Entries {
+		if e.Caller.ObjFile.Symbols[e.Rel.Sym] == sym && e.Rel.Addend == rel.Addend {
+			return e
+		}
+	}
+
+	e := &ThunkEntry{
+		Thunk:  t,
+		Offset: uint64(len(t.Entries) * ThunkEntrySize),
+		Caller: caller,
+		Rel:    *rel,
+	}
+	t.Entries = append(t.Entries, e)
+	t.InputSection.Shdr.Size = uint64(len(t.Entries) * ThunkEntrySize)
+	t.InputSection.SetInputSectionSize(t.InputSection.Shdr.Size)
+
+	// a local symbol for each entry, e.g. __thunk_foo
+	name := "__thunk_" + sym.Name
+	if sym.Name == "" && sym.InputSection != nil {
+		name = fmt.Sprintf("__thunk_%s+0x%x", sym.InputSection.Name, rel.Addend)
+	}
+	// only listed in .symtab, the symbol table of the internal file is
+	// not changed since its globals are resolved already
+	obj := ctx.InternalObj
+	e.Sym = NewSymbol(obj, name)
+	e.Sym.SetInputSection(t.InputSection)
+	e.Sym.SetValue(e.Offset)
+	e.Sym.SetSymIdx(thunkSymIdx)
+	obj.LocalSymbols = append(obj.LocalSymbols, e.Sym)
+	return e
+}
+
+func (t *Thunk) WriteTo(ctx *Context, buf []byte) {
+	for _, e := range t.Entries {
+		S, A := e.Caller.ObjFile.GetRelSymbolAddrAndAddend(ctx, &e.Rel)
+		val := S + A - e.GetAddr()
+		e.Caller.checkHi20Range(&e.Rel, val)
+
+		loc := buf[e.Offset:]
+		utils.Write[uint32](loc, 0x00000317)     // auipc t1, 0
+		utils.Write[uint32](loc[4:], 0x00030067) // jalr x0, 0(t1)
+		writeUtype(loc, uint32(val))
+		writeItype(loc[4:], uint32(val))
+	}
+}
+
+// finds JAL, widened BRANCH and relaxed CALL that cannot reach their target with
+// the current addresses and redirects them to a thunk
+// a relocation stays redirected, so thunks only grow and the layout converges
+// returns true if the layout has to be done again
+func CreateThunks(ctx *Context) bool {
+	changed := false
+	for _, file := range ctx.Args.ObjFiles {
+		for _, isec := range file.InputSections {
+			if isec == nil || !isec.IsAlive || isec.RelSecIdx == 0 ||
+				isec.Shdr.Flags&uint64(elf.SHF_EXECINSTR) == 0 {
+				continue
+			}
+
+			rels := isec.GetRels()
+			for idx := range rels {
+				rel := &rels[idx]
+				// where the jal is
+				P := isec.GetAddr() + isec.GetRelaxedOffset(rel.Offset)
+				switch elf.R_RISCV(rel.Type) {
+				case elf.R_RISCV_JAL:
+				case elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT:
+					// relaxed to jal, it is not turned back into auipc + jalr
+					if len(isec.RelaxRemoved) <= idx || isec.RelaxRemoved[idx] != 4 {
+						continue
+					}
+				case elf.R_RISCV_BRANCH:
+					if len(isec.RelaxRemoved) <= idx || isec.RelaxRemoved[idx] >= 0 {
+						continue
+					}
+					P += 4
+				default:
+					continue
+				}
+				if _, ok := isec.ThunkRefs[idx]; ok {
+					continue
+				}
+				// undefined symbols become jumps to themselves
+				if file.Symbols[rel.Sym].File == nil {
+					continue
+				}
+
+				S, A := file.GetRelSymbolAddrAndAddend(ctx, rel)
+				if isInt(S+A-P, 21) {
+					continue
+				}
+
+				if isec.CallerThunk == nil {
+					isec.CallerThunk = NewThunk(ctx, isec)
+				}
+				if isec.ThunkRefs == nil {
+					isec.ThunkRefs = make(map[int]*ThunkEntry)
+				}
+				isec.ThunkRefs[idx] = isec.CallerThunk.GetEntry(ctx, isec, rel)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,144 @@
+package linker
+
+import (
+	"github.com/hcyang1106/simple-linker/pkg/utils"
+	"path"
+	"strings"
+)
+
+// a version script (--version-script) names the versions a shared object
+// defines and decides which of its symbols are exported, e.g.
+//
+//	VER_1 { global: foo; bar*; local: *; };
+//	VER_2 { global: baz; } VER_1;
+//
+// a node without a name only decides what is exported
+// the version of node i is i+2 in .gnu.version, 1 is the shared object itself
+type VersionPattern struct {
+	Pattern string
+	VerIdx  uint16 // VER_NDX_LOCAL for local:
+	IsGlob  bool
+}
+
+func (c *Context) ReadVersionScript(file *File) {
+	tokens := tokenizeScript(string(file.Content))
+	for len(tokens) > 0 {
+		verIdx := VER_NDX_GLOBAL
+		if tokens[0] != "{" {
+			c.VersionNames = append(c.VersionNames, tokens[0])
+			verIdx = uint16(len(c.VersionNames) + 1)
+			tokens = tokens[1:]
+		}
+		tokens = c.readVersionNode(file, tokens, verIdx)
+
+		// the versions it depends on, only for documentation
+		for len(tokens) > 0 && tokens[0] != ";" {
+			tokens = tokens[1:]
+		}
+		tokens = skipVersionScriptToken(file, tokens, ";")
+	}
+}
+
+func skipVersionScriptToken(file *File, tokens []string, tok string) []string {
+	if len(tokens) == 0 || tokens[0] != tok {
+		utils.Fatal(file.Name + ": expected " + tok + " in version script")
+	}
+	return tokens[1:]
+}
+
+// { global: foo; local: *; }, symbols are global until local: is seen
+func (c *Context) readVersionNode(file *File, tokens []string, verIdx uint16) []string {
+	tokens = skipVersionScriptToken(file, tokens, "{")
+	idx := verIdx
+	for len(tokens) > 0 && tokens[0] != "}" {
+		tok := tokens[0]
+		tokens = tokens[1:]
+		if len(tokens) > 0 && tokens[0] == ":" {
+			switch tok {
+			case "global":
+				idx = verIdx
+			case "local":
+				idx = VER_NDX_LOCAL
+			default:
+				utils.Fatal(file.Name + ": unknown scope " + tok + " in version script")
+			}
+			tokens = tokens[1:]
+			continue
+		}
+
+		// extern "C++" { ... }, names are matched as written since
+		// they are not demangled
+		if tok == "extern" {
+			if len(tokens) > 0 && tokens[0] != `"C"` {
+				utils.Warn(file.Name + ": extern " + tokens[0] +
+					" in version script, names are matched without demangling")
+			}
+			tokens = c.readVersionPatterns(file, tokens[1:], idx)
+			if len(tokens) > 0 && tokens[0] == ";" {
+				tokens = tokens[1:]
+			}
+			continue
+		}
+
+		c.addVersionPattern(tok, idx)
+		tokens = skipVersionScriptToken(file, tokens, ";")
+	}
+	return skipVersionScriptToken(file, tokens, "}")
+}
+
+func (c *Context) readVersionPatterns(file *File, tokens []string, idx uint16) []string {
+	tokens = skipVersionScriptToken(file, tokens, "{")
+	for len(tokens) > 0 && tokens[0] != "}" {
+		if tokens[0] != ";" {
+			c.addVersionPattern(tokens[0], idx)
+		}
+		tokens = tokens[1:]
+	}
+	return skipVersionScriptToken(file, tokens, "}")
+}
+
+// a quoted name is matched as written, even if it looks like a glob
+func (c *Context) addVersionPattern(pattern string, idx uint16) {
+	quoted := len(pattern) >= 2 && strings.HasPrefix(pattern, `"`) && strings.HasSuffix(pattern, `"`)
+	if quoted {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+	c.VersionPatterns = append(c.VersionPatterns, VersionPattern{
+		Pattern: pattern,
+		VerIdx:  idx,
+		IsGlob:  !quoted && strings.ContainsAny(pattern, "*?["),
+	})
+}
+
+// exact names win over globs, and among globs the first match wins
+// a lone * is tried last, so local: * doesn't hide the other nodes
+func (c *Context) FindSymbolVersion(name string) (uint16, bool) {
+	for _, p := range c.VersionPatterns {
+		if !p.IsGlob && p.Pattern == name {
+			return p.VerIdx, true
+		}
+	}
+	for _, p := range c.VersionPatterns {
+		if p.IsGlob && p.Pattern != "*" {
+			if ok, _ := path.Match(p.Pattern, name); ok {
+				return p.VerIdx, true
+			}
+		}
+	}
+	for _, p := range c.VersionPatterns {
+		if p.Pattern == "*" {
+			return p.VerIdx, true
+		}
+	}
+	return VER_NDX_GLOBAL, false
+}
+
+// the index of a version node, 0 if the script doesn't have it
+func (c *Context) GetVersionIdx(name string) uint16 {
+	for idx, ver := range c.VersionNames {
+		if ver == name {
+			return uint16(idx + 2)
+		}
+	}
+	return 0
+}
@@ -15,6 +15,14 @@ func Fatal(v any) {
 	os.Exit(1)
 }
 
+func Error(v any) {
+	fmt.Printf("error: %v\n", v)
+}
+
+func Warn(v any) {
+	fmt.Printf("warning: %v\n", v)
+}
+
 func MustNo(err error) {
 	if err != nil {
 		Fatal(err)
@@ -147,6 +155,34 @@ func SignExtend(val uint64, size int) uint64 {
 	return uint64(int64(val<<(63-size)) >> (63 - size))
 }
 
+// returns the value and the number of bytes read
+func ReadUleb(buf []byte) (uint64, int) {
+	val := uint64(0)
+	shift := 0
+	for idx, b := range buf {
+		val |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return val, idx + 1
+		}
+		shift += 7
+	}
+	Fatal("bad uleb128")
+	return 0, 0
+}
+
+// keeps the length of the encoded value already in buf,
+// so that the bytes after it don't move
+// returns false if val doesn't fit in that length
+func WriteUlebInPlace(buf []byte, val uint64) bool {
+	_, n := ReadUleb(buf)
+	for idx := 0; idx < n-1; idx++ {
+		buf[idx] = byte(val&0x7f) | 0x80
+		val >>= 7
+	}
+	buf[n-1] = byte(val & 0x7f)
+	return val>>7 == 0
+}
+
 func AllZeros(bs []byte) bool {
 	b := byte(0)
 	for _, s := range bs {
@@ -155,3 +191,34 @@ func AllZeros(bs []byte) bool {
 
 	return b == 0
 }
+
+// levenshtein distance, used to find similar names
+func EditDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// printable ascii and whitespace only, checks the first 4KiB
+func IsText(bs []byte) bool {
+	for _, b := range bs[:min(len(bs), 4096)] {
+		if (b < 0x20 || b > 0x7e) && b != '\n' && b != '\r' && b != '\t' {
+			return false
+		}
+	}
+	return true
+}
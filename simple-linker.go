@@ -39,16 +39,45 @@ func main() {
 
 	ctx.FillInObjFiles(remaining) // remaining contains specific libraries or obj files
 
+	// each global symbol is owned by the best definition (strong, weak, common, archive)
+	linker.ResolveSymbols(ctx)
 	linker.MarkLiveObjects(ctx)
+	// keep only one copy of each comdat group (inline functions, templates)
+	linker.ResolveComdatGroups(ctx)
+	linker.ResolveSymbols(ctx)
+	// --as-needed, drop shared objects nobody uses
+	linker.RemoveUnneededSharedFiles(ctx)
+	linker.CheckDuplicateSymbols(ctx)
+	linker.MergeCommonSymbols(ctx)
+	// --version-script, foo@@VER, and which symbols stay local
+	linker.ApplyVersionScript(ctx)
+
+	// for linker defined symbols and common symbols
+	ctx.CreateInternalFile()
 
 	//linker.ClearSymbolsAndFiles(ctx) // after marking alive files, we delete unused files and symbols in context
 
 	linker.ParseFiles(ctx)
 
+	// commons don't belong to any section, put them into .bss
+	linker.AllocateCommonSymbols(ctx)
+
 	// loop through all the symbols in file and reset related input section to fragment
 	// "value" inside symbols will also be modified to the offset inside a fragment
 	//linker.ChangeMSecsSymbolsSection(ctx)
 
+	// linker defined symbols such as _end and __global_pointer$
+	// output sections are needed for __start_SEC and __stop_SEC
+	linker.DefineInternalSymbols(ctx)
+
+	// --gc-sections, remove sections not reachable from the entry
+	linker.GcSections(ctx)
+	// --icf, fold identical functions after unused ones are gone
+	linker.FoldIdenticalSections(ctx)
+
+	// every symbol that could be defined is defined now
+	linker.ReportUndefinedSymbols(ctx)
+
 	// for shdr, ehdr, phdr, got
 	// need to update size and offset, but before that outputwriters slice should be confirmed
 	// also need to update ehdr fields
@@ -62,6 +91,16 @@ func main() {
 	// output section's input sections cannot be set at first because some will turn into non-alive afterwards
 	// whereas merged section's fragments are already setup since created
 	linker.SetOutputSectionInputSections(ctx)
+
+	// only TLS symbols will appear in GOT
+	// got size has to be known before shndxs are assigned
+	// copies of shared object data are added to .bss, so before its size is known
+	linker.ScanRelsAndAddSymsToGot(ctx)
+	// imported and exported symbols, sorted for .gnu.hash
+	linker.CreateDynamicSymbols(ctx)
+	// .gnu.version, .gnu.version_d and .gnu.version_r
+	linker.CreateVersionSections(ctx)
+
 	// same as frags, need to confirm the containing input sections first
 	// so that offset and size can be calculated
 	// to my understanding, sorting is not used here because sections are not that many, so unlike fragments
@@ -70,21 +109,47 @@ func main() {
 
 	writers := linker.CollectOutputSectionWritersAndMergedSectionWriters(ctx)
 	ctx.OutputWriters = append(ctx.OutputWriters, writers...)
-	// ehdr, phdr, note, non-alloc after alloc, shdr last
+	// ehdr, phdr, note, non-alloc after alloc, symtab, shdr last
 	linker.SortOutputWriters(ctx)
 
+	// section indexes follow the sorted order, used by shdr, shstrtab and symtab
+	linker.SetOutputWriterShndxs(ctx)
+
 	// size cannot be confirmed until all writers all confirmed
 	// seemed to be redundant
 	for _, o := range ctx.OutputWriters {
 		o.UpdateSize(ctx) // this is only for phdr and shdr (only for headers)
 	}
 
-	// only TLS symbols will appear in GOT
-	linker.ScanRelsAndAddSymsToGot(ctx)
-
 	// set offset of all the writers
 	// should be after sizes are set
 	fileSize := linker.SetOutputShdrOffsets(ctx)
+	linker.FixInternalSymbols(ctx)
+	linker.FixEhFrameSymbols(ctx)
+
+	// relaxation removes bytes and thunks are added with the addresses
+	// of the last layout, so the layout is done again until nothing changes
+	// .relr.dyn also depends on the addresses it relocates
+	for iter := 0; ; iter++ {
+		relaxed := linker.RelaxSections(ctx)
+		thunked := linker.CreateThunks(ctx)
+		packed := linker.UpdateRelrDynSize(ctx)
+		if !relaxed && !thunked && !packed {
+			break
+		}
+		if iter == 30 {
+			utils.Fatal("layout does not converge")
+		}
+		linker.UpdateInputSectionOffsetAndOutputSectionSizeAlign(ctx)
+		// thunks add symbols to .symtab
+		for _, o := range ctx.OutputWriters {
+			o.UpdateSize(ctx)
+		}
+		fileSize = linker.SetOutputShdrOffsets(ctx)
+		linker.FixInternalSymbols(ctx)
+		linker.FixEhFrameSymbols(ctx)
+	}
+	linker.PrintMap(ctx)
 	println("File Size:", fileSize, "bytes")
 	ctx.Buf = make([]byte, fileSize)
 	file, err := os.OpenFile(ctx.Args.Output, os.O_RDWR | os.O_CREATE, 0777)
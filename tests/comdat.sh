@@ -0,0 +1,35 @@
+#!/bin/bash
+
+# only the first copy of a COMDAT group is kept
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# like an inline function emitted by every object that uses it,
+# the copies differ so that we can tell which one is used
+for value in 42 1; do
+cat <<EOF | $CC -xassembler - -c -o $test_path/group$value.o
+	.section .text.get_value,"axG",@progbits,get_value,comdat
+	.globl get_value
+	.type get_value, @function
+get_value:
+	li a0, $value
+	ret
+EOF
+done
+
+# no libc, _start calls main and exits with its return value
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/main.o
+int get_value(void);
+int main(void) { return get_value(); }
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+# no multiple definition, the group of the first file wins
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/main.o $test_path/group42.o \
+    $test_path/group1.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+test $(readelf -s $test_path/out | grep -c ' get_value$') = 1 || exit 1
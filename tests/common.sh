@@ -0,0 +1,44 @@
+#!/bin/bash
+
+# tentative definitions (-fcommon) are merged and allocated into .bss
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# no libc, _start calls main and exits with its return value
+cat <<EOF | $CC -xc - -c -fno-pic -fcommon -o $test_path/main.o
+int buf[2];
+int counter;
+int aligned_buf[1] __attribute__((aligned(64)));
+int main(void) {
+    for (int i = 0; i < 8; i++)
+        ((volatile int *)buf)[i] = i;
+    aligned_buf[0] = 2;
+    return counter + aligned_buf[0] + buf[1] - 1;
+}
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+# the largest common wins, and a real definition wins over commons
+cat <<EOF | $CC -xc - -c -fno-pic -fcommon -o $test_path/b.o
+int buf[8];
+int counter = 40;
+EOF
+
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/main.o $test_path/b.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+# buf takes 8 ints in .bss
+readelf -sW $test_path/out | awk '$8 == "buf" { print $3 }' | grep -q '^32$' || exit 1
+ndx=$(readelf -sW $test_path/out | awk '$8 == "buf" { print $7 }')
+readelf -SW $test_path/out | grep -q "\[ *$ndx\] \.bss " || exit 1
+addr=$(readelf -sW $test_path/out | awk '$8 == "aligned_buf" { print $2 }')
+test $((16#$addr % 64)) = 0 || exit 1
+
+$CC -B. -static -nostdlib -Wl,--no-relax -Wl,--warn-common -Wl,--sort-common \
+    $test_path/main.o $test_path/b.o -o $test_path/warn > $test_path/log 2>&1 || exit 1
+grep -q 'multiple common of .buf' $test_path/log || exit 1
+grep -q 'common of .counter. overridden by definition' $test_path/log || exit 1
@@ -0,0 +1,89 @@
+#!/bin/bash
+
+# label differences across relaxed code are fixed up by the linker, a value
+# that does not fit and an unknown relocation are errors
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# exits with the index of the first wrong table entry, or 42
+cat <<EOF | $CC -xassembler - -c -o $test_path/a.o
+	.globl _start
+_start:
+	# the assembler does not know how long the relaxed call is, so it
+	# leaves the differences to the linker
+1:	call add1
+2:	lla t0, 1b
+	lla t1, 2b
+	sub t1, t1, t0
+	lla t0, table
+	li a0, 1
+	lbu t2, 0(t0)
+	bne t1, t2, 3f
+	li a0, 2
+	lbu t2, 1(t0)
+	bne t1, t2, 3f
+	li a0, 3
+	lhu t2, 2(t0)
+	bne t1, t2, 3f
+	li a0, 4
+	lwu t2, 4(t0)
+	bne t1, t2, 3f
+	li a0, 5
+	ld t2, 8(t0)
+	bne t1, t2, 3f
+	li a0, 42
+3:	li a7, 93
+	ecall
+
+add1:
+	addi a0, a0, 1
+	ret
+
+	.section .rodata
+	.p2align 3
+table:
+	.uleb128 2b - 1b
+	.byte 2b - 1b
+	.2byte 2b - 1b
+	.4byte 2b - 1b
+	.8byte 2b - 1b
+EOF
+
+$CC -B. -static -nostdlib -Wl,--relax $test_path/a.o -o $test_path/relax
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/a.o -o $test_path/norelax
+qemu-riscv64 $test_path/relax
+test $? = 42 || exit 1
+qemu-riscv64 $test_path/norelax
+test $? = 42 || exit 1
+
+# 200 needs two bytes of ULEB128, only one is there
+cat <<EOF | $CC -xassembler - -c -o $test_path/uleb.o
+	.data
+	.globl uleb_start, uleb_end
+uleb_start:
+	.skip 200
+uleb_end:
+	.section .rodata
+1:	.byte 0
+	.reloc 1b, R_RISCV_SET_ULEB128, uleb_end
+	.reloc 1b, R_RISCV_SUB_ULEB128, uleb_start
+EOF
+
+! $CC -B. -static -nostdlib $test_path/a.o $test_path/uleb.o \
+    -o $test_path/uleb > $test_path/uleb.log 2>&1 || exit 1
+grep -q "uleb.o:(.rodata+0x0): relocation R_RISCV_SET_ULEB128 against .uleb_end. out of range: 200 does not fit in 1 bytes" \
+    $test_path/uleb.log || exit 1
+
+# R_RISCV_COPY only belongs in the output
+cat <<EOF | $CC -xassembler - -c -o $test_path/copy.o
+	.data
+	.reloc ., R_RISCV_COPY, _start
+	.8byte 0
+EOF
+
+! $CC -B. -static -nostdlib $test_path/a.o $test_path/copy.o \
+    -o $test_path/copy > $test_path/copy.log 2>&1 || exit 1
+grep -q "copy.o:(.data+0x0): unknown relocation R_RISCV_COPY" $test_path/copy.log || exit 1
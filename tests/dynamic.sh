@@ -0,0 +1,45 @@
+#!/bin/bash
+
+# hello world linked against libc.so, run by the dynamic loader
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -o $test_path/a.o
+#include <stdio.h>
+#include <stdlib.h>
+int main(void) {
+    printf("Hello, World\n");
+    int *p = malloc(sizeof(int));
+    *p = 10;
+    printf("%d\n", *p);
+    return 0;
+}
+EOF
+
+# without -static, gcc passes libc.so (a linker script) and the dynamic loader
+$CC -B. $test_path/a.o -o $test_path/out
+readelf -d $test_path/out | grep -q 'NEEDED.*libc.so.6' || exit 1
+
+# -L => where qemu finds the dynamic loader and libc.so.6
+qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/out > $test_path/log
+grep -q 'Hello, World' $test_path/log && grep -q '^10$' $test_path/log || exit 1
+
+# a function only called back by a shared object survives --gc-sections
+cat <<EOF | $CC -xc - -shared -fPIC -o $test_path/libcb.so
+int cb(void);
+int call_cb(void) { return cb(); }
+EOF
+
+cat <<EOF | $CC -xc - -c -ffunction-sections -o $test_path/b.o
+int call_cb(void);
+int cb(void) { return 42; }
+int main(void) { return call_cb(); }
+EOF
+
+$CC -B. -Wl,--gc-sections $test_path/b.o -L$test_path -lcb -o $test_path/gc
+readelf --dyn-syms $test_path/gc | grep -q ' cb$' || exit 1
+LD_LIBRARY_PATH=$test_path qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/gc
+test $? = 42 || exit 1
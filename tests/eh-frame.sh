@@ -0,0 +1,37 @@
+#!/bin/bash
+
+# the unwinder finds the FDEs of the output through .eh_frame_hdr
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# -O0 keeps every call a real frame
+cat <<EOF | $CC -xc - -c -O0 -funwind-tables -o $test_path/a.o
+#include <stdio.h>
+#include <unwind.h>
+static _Unwind_Reason_Code count(struct _Unwind_Context *ctx, void *arg) {
+    (*(int *)arg)++;
+    return _URC_NO_REASON;
+}
+int depth(int n) {
+    if (n == 0) {
+        int frames = 0;
+        _Unwind_Backtrace(count, &frames);
+        return frames;
+    }
+    return depth(n - 1);
+}
+int main(void) {
+    // depth 5 to 0, main and libc start code
+    printf("%s\n", depth(5) >= 8 ? "ok" : "short");
+    return 0;
+}
+EOF
+
+$CC -B. -static -Wl,--eh-frame-hdr $test_path/a.o -o $test_path/out
+test "$(qemu-riscv64 $test_path/out)" = ok || exit 1
+
+readelf -lW $test_path/out | grep -q GNU_EH_FRAME || exit 1
+readelf -SW $test_path/out | grep -q '\.eh_frame_hdr' || exit 1
@@ -0,0 +1,43 @@
+#!/bin/bash
+
+# -e picks the entry point, and the linker defines the symbols crt code uses
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# no libc, _start calls main and exits with its return value
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/a.o
+__attribute__((section("my_sec"), used)) static int vals[] = { 10, 12, 20 };
+extern int __start_my_sec[], __stop_my_sec[];
+extern char __ehdr_start[], _etext[], _edata[], __bss_start[], _end[];
+
+int main(void) {
+    if (!(__ehdr_start < _etext && _etext <= _edata &&
+          _edata <= __bss_start && __bss_start <= _end))
+        return 1;
+    int sum = 0;
+    for (int *p = __start_my_sec; p < __stop_my_sec; p++)
+        sum += *p;
+    return sum;
+}
+
+void alt_start(void) {
+    asm volatile("li a0, 7\n\tli a7, 93\n\tecall");
+}
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/a.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+$CC -B. -static -nostdlib -Wl,--no-relax -Wl,-e,alt_start $test_path/a.o -o $test_path/alt
+qemu-riscv64 $test_path/alt
+test $? = 7 || exit 1
+
+# the entry point in the elf header is alt_start
+entry=$(readelf -h $test_path/alt | awk '/Entry point/ { print $4 }')
+alt_start=$(readelf -sW $test_path/alt | awk '$8 == "alt_start" { print $2 }')
+test $(($entry)) = $((16#$alt_start)) || exit 1
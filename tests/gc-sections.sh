@@ -0,0 +1,38 @@
+#!/bin/bash
+
+# --gc-sections removes unreferenced sections, but keeps debug info
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# -ffunction-sections => one section per function, so unused ones can be removed
+cat <<EOF | $CC -xc - -c -g -ffunction-sections -fdata-sections -o $test_path/a.o
+#include <stdio.h>
+void unused_func(void) { printf("unused\n"); }
+int main(void) {
+    printf("Hello, World\n");
+    return 0;
+}
+EOF
+
+$CC -B. -static -Wl,--gc-sections $test_path/a.o -o $test_path/out
+qemu-riscv64 $test_path/out | grep -q 'Hello, World' || exit 1
+
+# the unused function is gone, debug strings are not
+! readelf -s $test_path/out | grep -q unused_func || exit 1
+readelf -p .debug_str $test_path/out | grep -q main || exit 1
+
+# a string only named by a symbol is dropped from the merged section,
+# and so is its symbol
+cat <<EOF | $CC -xassembler - -c -o $test_path/str.o
+	.section .rodata.str1.1,"aMS",@progbits,1
+	.globl unused_str
+unused_str:
+	.asciz "never used"
+EOF
+
+$CC -B. -static -Wl,--gc-sections $test_path/a.o $test_path/str.o -o $test_path/str
+qemu-riscv64 $test_path/str | grep -q 'Hello, World' || exit 1
+! readelf -s $test_path/str | grep -q unused_str || exit 1
@@ -0,0 +1,37 @@
+#!/bin/bash
+
+# -fPIC code reaches globals through the GOT, also when linked statically
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -fPIC -o $test_path/a.o
+extern int value;
+extern int missing __attribute__((weak));
+int add2(int x);
+int (*fp)(int) = add2;
+
+int main(void) {
+    if (&missing)
+        return 1;
+    return fp(value);
+}
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+cat <<EOF | $CC -xc - -c -fPIC -o $test_path/b.o
+int value = 40;
+int add2(int x) { return x + 2; }
+EOF
+
+$CC -B. -static -nostdlib -Wl,--relax $test_path/a.o $test_path/b.o -o $test_path/relax
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/a.o $test_path/b.o -o $test_path/norelax
+qemu-riscv64 $test_path/relax
+test $? = 42 || exit 1
+qemu-riscv64 $test_path/norelax
+test $? = 42 || exit 1
+
+# without relaxation the addresses are loaded from .got
+readelf -S $test_path/norelax | grep -q '\.got' || exit 1
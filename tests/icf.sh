@@ -0,0 +1,28 @@
+#!/bin/bash
+
+# --icf=all folds identical functions, exceptions still unwind through them
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# f1 and f2 are identical, so are their FDEs
+cat <<EOF | ${CC/gcc/g++} -xc++ - -c -O1 -ffunction-sections -o $test_path/a.o
+#include <cstdio>
+__attribute__((noinline)) void f1(int x) { if (x) throw x; }
+__attribute__((noinline)) void f2(int x) { if (x) throw x; }
+int main() {
+    try { f1(1); } catch (int e) { printf("caught %d\n", e); }
+    try { f2(2); } catch (int e) { printf("caught %d\n", e); }
+    return 0;
+}
+EOF
+
+${CC/gcc/g++} -B. -static -Wl,--icf=all $test_path/a.o -o $test_path/out
+qemu-riscv64 $test_path/out > $test_path/log
+grep -q 'caught 1' $test_path/log && grep -q 'caught 2' $test_path/log || exit 1
+
+# f1 and f2 share an address
+test "$(readelf -sW $test_path/out | awk '/ _Z2f1i$/ { print $2 }')" = \
+    "$(readelf -sW $test_path/out | awk '/ _Z2f2i$/ { print $2 }')" || exit 1
@@ -0,0 +1,36 @@
+#!/bin/bash
+
+# an ifunc is bound to what its resolver returns before main runs
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/a.o
+static int answer_impl(void) { return 42; }
+static int wrong_impl(void) { return 1; }
+static int (*resolve_answer(void))(void) {
+    return 1 ? answer_impl : wrong_impl;
+}
+int answer(void) __attribute__((ifunc("resolve_answer")));
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/b.o
+#include <string.h>
+int answer(void);
+int (*answer_ptr)(void) = answer;
+
+int main(void) {
+    if (strlen("hello") != 5 || answer_ptr() != answer())
+        return 1;
+    return answer();
+}
+EOF
+
+$CC -B. -static $test_path/a.o $test_path/b.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+readelf -r $test_path/out | grep -q R_RISCV_IRELATIVE || exit 1
+readelf -S $test_path/out | grep -q '\.iplt' || exit 1
@@ -0,0 +1,53 @@
+#!/bin/bash
+
+# strong definitions beat weak ones, two strong ones are an error
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# no libc, _start calls main and exits with its return value
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/main.o
+extern int value;
+int two(void);
+int main(void) { return value + two(); }
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/strong.o
+int value = 40;
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/other.o
+int value = 1;
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/weak.o
+__attribute__((weak)) int value = 2;
+int two(void) { return 2; }
+EOF
+
+link() {
+    $CC -B. -static -nostdlib -Wl,--no-relax "$@"
+}
+
+# the weak definition loses whatever the order is
+link $test_path/main.o $test_path/weak.o $test_path/strong.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+! link $test_path/main.o $test_path/strong.o $test_path/other.o $test_path/weak.o \
+    -o $test_path/dup > $test_path/log 2>&1 || exit 1
+grep -q 'multiple definition of .value' $test_path/log || exit 1
+
+# the first definition is used
+link -Wl,--allow-multiple-definition $test_path/main.o $test_path/strong.o \
+    $test_path/other.o $test_path/weak.o -o $test_path/allow
+qemu-riscv64 $test_path/allow
+test $? = 42 || exit 1
+
+link -Wl,-z,muldefs $test_path/main.o $test_path/strong.o \
+    $test_path/other.o $test_path/weak.o -o $test_path/muldefs
+qemu-riscv64 $test_path/muldefs
+test $? = 42 || exit 1
@@ -0,0 +1,28 @@
+#!/bin/bash
+
+# position independent executables, loaded by ld.so or relocating themselves
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# the pointers in data become R_RISCV_RELATIVE
+cat <<EOF | $CC -xc - -c -fPIE -o $test_path/a.o
+#include <stdio.h>
+static const char *msgs[] = { "Hello", "World" };
+int main(void) {
+    printf("%s, %s\n", msgs[0], msgs[1]);
+    return 0;
+}
+EOF
+
+# -pie, the dynamic loader picks the address
+$CC -B. -pie $test_path/a.o -o $test_path/pie
+readelf -h $test_path/pie | grep -q 'DYN' || exit 1
+qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/pie | grep -q 'Hello, World' || exit 1
+
+# -static-pie, gcc passes -static -pie --no-dynamic-linker
+$CC -B. -static-pie $test_path/a.o -o $test_path/static-pie
+! readelf -l $test_path/static-pie | grep -q INTERP || exit 1
+qemu-riscv64 $test_path/static-pie | grep -q 'Hello, World' || exit 1
@@ -0,0 +1,44 @@
+#!/bin/bash
+
+# relocation values that do not fit their field, or are not aligned, are errors
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# far is beyond the 32-bit range of lui, odd is not a multiple of 2
+cat <<EOF | $CC -xassembler - -c -o $test_path/defs.o
+	.text
+target:
+	ret
+	.globl far, odd
+	.set far, 0x100000000
+	.set odd, target + 1
+EOF
+
+cat <<EOF | $CC -xassembler - -c -o $test_path/hi20.o
+	.globl _start
+_start:
+	lui a0, %hi(far)
+	li a7, 93
+	ecall
+EOF
+
+cat <<EOF | $CC -xassembler - -c -o $test_path/jal.o
+	.globl _start
+_start:
+	jal odd
+	li a7, 93
+	ecall
+EOF
+
+! $CC -B. -static -nostdlib -Wl,--no-relax $test_path/hi20.o $test_path/defs.o \
+    -o $test_path/hi20 > $test_path/hi20.log 2>&1 || exit 1
+grep -q "hi20.o:(.text+0x0): relocation R_RISCV_HI20 against .far. out of range: 4294967296 is not in" \
+    $test_path/hi20.log || exit 1
+
+! $CC -B. -static -nostdlib -Wl,--no-relax $test_path/jal.o $test_path/defs.o \
+    -o $test_path/jal > $test_path/jal.log 2>&1 || exit 1
+grep -q "jal.o:(.text+0x0): relocation R_RISCV_JAL against .odd. is not aligned: .* is not a multiple of 2" \
+    $test_path/jal.log || exit 1
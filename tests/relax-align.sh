@@ -0,0 +1,63 @@
+#!/bin/bash
+
+# calls, branches and alignments that move each other around while relaxing
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# every call is relaxed, each .p2align keeps a different amount of padding,
+# and the branches cross both
+cat <<EOF | $CC -xassembler - -c -o $test_path/a.o
+	.text
+	.globl main
+main:
+	addi sp, sp, -16
+	sd ra, 8(sp)
+	sd s1, 0(sp)
+	li a0, 0
+	call add1
+	.p2align 3
+	call add1
+	beqz a0, fail
+	.p2align 4
+	call add2
+	bnez a0, 1f
+	j fail
+	.p2align 5
+1:	li s1, 10
+2:	call add1
+	.p2align 3
+	addi s1, s1, -1
+	bnez s1, 2b
+	ld ra, 8(sp)
+	ld s1, 0(sp)
+	addi sp, sp, 16
+	tail add28
+
+fail:
+	li a0, 1
+	ld ra, 8(sp)
+	ld s1, 0(sp)
+	addi sp, sp, 16
+	ret
+
+	.p2align 2
+add1:
+	addi a0, a0, 1
+	ret
+add2:
+	addi a0, a0, 2
+	ret
+add28:
+	addi a0, a0, 28
+	ret
+EOF
+
+$CC -B. -static -Wl,--relax $test_path/a.o -o $test_path/relax
+$CC -B. -static -Wl,--no-relax $test_path/a.o -o $test_path/norelax
+qemu-riscv64 $test_path/relax
+test $? = 42 || exit 1
+qemu-riscv64 $test_path/norelax
+test $? = 42 || exit 1
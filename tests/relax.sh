@@ -0,0 +1,30 @@
+#!/bin/bash
+
+# the same program works with and without linker relaxation
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -O1 -o $test_path/a.o
+#include <stdio.h>
+int counter = 1;
+static int bump(int x) { return counter += x; }
+int main(void) {
+    for (int i = 0; i < 10; i++)
+        bump(i);
+    printf("%d\n", counter);
+    return 0;
+}
+EOF
+
+$CC -B. -static -Wl,--relax $test_path/a.o -o $test_path/relax
+$CC -B. -static -Wl,--no-relax $test_path/a.o -o $test_path/norelax
+test "$(qemu-riscv64 $test_path/relax)" = 46 || exit 1
+test "$(qemu-riscv64 $test_path/norelax)" = 46 || exit 1
+
+# relaxation only ever makes the code smaller
+relax_size=$(size -A $test_path/relax | awk '$1 == ".text" { print $2 }')
+norelax_size=$(size -A $test_path/norelax | awk '$1 == ".text" { print $2 }')
+test $relax_size -lt $norelax_size || exit 1
@@ -0,0 +1,33 @@
+#!/bin/bash
+
+# -z pack-relative-relocs moves R_RISCV_RELATIVE into .relr.dyn
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# a table of pointers, each one is a relative relocation in a pie
+cat <<EOF | $CC -xc - -c -fPIE -o $test_path/a.o
+#include <stdio.h>
+static int values[8] = { 1, 2, 3, 4, 5, 6, 7, 8 };
+static int *ptrs[8] = { &values[0], &values[1], &values[2], &values[3],
+                        &values[4], &values[5], &values[6], &values[7] };
+int main(void) {
+    int sum = 0;
+    for (int i = 0; i < 8; i++)
+        sum += *ptrs[i];
+    printf("%d\n", sum);
+    return 0;
+}
+EOF
+
+$CC -B. -pie -Wl,-z,pack-relative-relocs $test_path/a.o -o $test_path/out
+readelf -S $test_path/out | grep -q '.relr.dyn' || exit 1
+readelf -d $test_path/out | grep -q 'RELR' || exit 1
+test "$(qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/out)" = 36 || exit 1
+
+# the same program without packing
+$CC -B. -pie $test_path/a.o -o $test_path/out2
+! readelf -S $test_path/out2 | grep -q '.relr.dyn' || exit 1
+test "$(qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/out2)" = 36 || exit 1
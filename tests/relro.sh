@@ -0,0 +1,32 @@
+#!/bin/bash
+
+# data only written by relocations is made read-only after loading, and
+# -z now binds every function before main
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# table is in .data.rel.ro, since it needs relocations but is const
+cat <<EOF | $CC -xc - -c -fPIC -o $test_path/a.o
+#include <stdio.h>
+#include <stdlib.h>
+static int value = 40;
+int *const table[] = { &value };
+int main(void) {
+    printf("%d\n", *table[0] + abs(-2));
+    return 0;
+}
+EOF
+
+$CC -B. -pie -Wl,-z,relro,-z,now $test_path/a.o -o $test_path/now
+readelf -lW $test_path/now | grep -q GNU_RELRO || exit 1
+readelf -d $test_path/now | grep -q 'BIND_NOW' || exit 1
+readelf -d $test_path/now | grep -q 'Flags: NOW' || exit 1
+test "$(qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/now)" = 42 || exit 1
+
+$CC -B. -pie -Wl,-z,norelro $test_path/a.o -o $test_path/norelro
+! readelf -lW $test_path/norelro | grep -q GNU_RELRO || exit 1
+! readelf -d $test_path/norelro | grep -q 'BIND_NOW' || exit 1
+test "$(qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/norelro)" = 42 || exit 1
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+# compressed branches and jumps that cannot reach are widened, and c.lui of
+# a weak undefined symbol becomes c.li
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# the compressed instructions are written by hand, so that the assembler
+# does not widen them itself
+cat <<EOF | $CC -xassembler - -c -o $test_path/a.o
+	.option rvc
+	.weak missing
+	.globl _start, is_zero, finish
+_start:
+	li a0, 1
+	# c.lui a0, %hi(missing)
+1:	.2byte 0x6505
+	.reloc 1b, R_RISCV_RVC_LUI, missing
+	# c.beqz a0, is_zero
+2:	.2byte 0xc101
+	.reloc 2b, R_RISCV_RVC_BRANCH, is_zero
+	li a0, 1
+	li a7, 93
+	ecall
+
+	.section .text.far,"ax",@progbits
+	.skip 1024
+is_zero:
+	li a0, 40
+	# c.j finish
+3:	.2byte 0xa001
+	.reloc 3b, R_RISCV_RVC_JUMP, finish
+	li a0, 1
+	li a7, 93
+	ecall
+	.skip 4096
+finish:
+	addi a0, a0, 2
+	li a7, 93
+	ecall
+EOF
+
+$CC -B. -static -nostdlib -Wl,--relax $test_path/a.o -o $test_path/relax
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/a.o -o $test_path/norelax
+qemu-riscv64 $test_path/relax
+test $? = 42 || exit 1
+qemu-riscv64 $test_path/norelax
+test $? = 42 || exit 1
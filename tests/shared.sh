@@ -0,0 +1,33 @@
+#!/bin/bash
+
+# a shared object made by simple-linker, used by an executable through ld.so
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# counter is preemptible, hidden_inc is bound locally, and the tls
+# variable is reached through a tls descriptor
+cat <<EOF | $CC -xc - -c -fPIC -mtls-dialect=desc -o $test_path/lib.o
+int counter = 40;
+__thread int tls_counter = 1;
+__attribute__((visibility("hidden"))) void hidden_inc(void) { counter++; }
+int get(void) { hidden_inc(); hidden_inc(); return counter + --tls_counter; }
+EOF
+
+cat <<EOF | $CC -xc - -c -o $test_path/main.o
+#include <stdio.h>
+int get(void);
+int main(void) {
+    printf("%d\n", get());
+    return 0;
+}
+EOF
+
+$CC -B. -shared $test_path/lib.o -o $test_path/libtest.so -Wl,-soname,libtest.so
+readelf -h $test_path/libtest.so | grep -q 'DYN' || exit 1
+! readelf --dyn-syms $test_path/libtest.so | grep -q hidden_inc || exit 1
+
+$CC -B. $test_path/main.o -L$test_path -ltest -o $test_path/out
+test "$(LD_LIBRARY_PATH=$test_path qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/out)" = 42 || exit 1
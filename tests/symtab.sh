@@ -0,0 +1,30 @@
+#!/bin/bash
+
+# the output has section headers, and .symtab lists local and global symbols
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# no libc, _start calls main and exits with its return value
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/a.o
+static int add(int a, int b) { return a + b; }
+int answer = 40;
+int main(void) { return add(answer, 2); }
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/a.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+readelf -S $test_path/out | grep -q '\.shstrtab' || exit 1
+readelf -S $test_path/out | grep -q '\.strtab' || exit 1
+readelf -s $test_path/out | grep -q 'LOCAL .* add$' || exit 1
+readelf -s $test_path/out | grep -q 'GLOBAL .* answer$' || exit 1
+
+# the symbol is in .data and has a real address
+ndx=$(readelf -sW $test_path/out | awk '$8 == "answer" { print $7 }')
+readelf -SW $test_path/out | grep -q "\[ *$ndx\] \.data " || exit 1
+! readelf -sW $test_path/out | awk '$8 == "answer" { print $2 }' | grep -q '^0*$' || exit 1
@@ -0,0 +1,50 @@
+#!/bin/bash
+
+# jumps that cannot reach their target go through a thunk
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# f is right at the edge of the ±1MiB a jal reaches, so some calls are
+# relaxed to jal first, then pushed out of reach once the far branches
+# are widened, and have to go through a thunk
+cat <<EOF | $CC -xassembler - -c -o $test_path/a.o
+	.option norvc
+	.section .text.a,"ax",@progbits
+	.globl main
+main:
+	addi sp, sp, -16
+	sd ra, 8(sp)
+	li a0, 0
+	.rept 16
+	call f
+	.endr
+	.p2align 4
+	.rept 16
+	beqz a0, bad
+	.endr
+	addi a0, a0, 26
+	ld ra, 8(sp)
+	addi sp, sp, 16
+	ret
+
+	.section .text.b,"ax",@progbits
+	.skip 8192
+bad:
+	li a0, 1
+	ld ra, 8(sp)
+	addi sp, sp, 16
+	ret
+	.skip 1040208
+f:
+	addi a0, a0, 1
+	ret
+EOF
+
+$CC -B. -static -Wl,-Map,$test_path/map $test_path/a.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+readelf -s $test_path/out | grep -q __thunk_f || exit 1
+grep -q __thunk_f $test_path/map || exit 1
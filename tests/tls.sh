@@ -0,0 +1,43 @@
+#!/bin/bash
+
+# local exec, initial exec and general dynamic accesses of thread locals in
+# a static executable
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/a.o
+#include <stdint.h>
+__thread int le_var = 10;
+__thread int bss_var;
+__thread int aligned_var __attribute__((aligned(64))) = 1;
+extern __thread int ie_var __attribute__((tls_model("initial-exec")));
+int get_gd(void);
+
+int main(void) {
+    if ((uintptr_t)&aligned_var % 64 != 0 || aligned_var != 1 || bss_var != 0)
+        return 1;
+    return le_var + ie_var + get_gd();
+}
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/b.o
+__thread int ie_var = 12;
+__thread int gd_var = 15;
+EOF
+
+# global and local dynamic, which go through __tls_get_addr
+cat <<EOF | $CC -xc - -c -fPIC -o $test_path/c.o
+extern __thread int gd_var;
+static __thread int ld_var = 5;
+int get_gd(void) { return gd_var + ld_var; }
+EOF
+
+$CC -B. -static $test_path/a.o $test_path/b.o $test_path/c.o -o $test_path/out
+qemu-riscv64 $test_path/out
+test $? = 42 || exit 1
+
+# the tls segment keeps the alignment of its most aligned variable
+readelf -lW $test_path/out | grep -q 'TLS .* 0x40$' || exit 1
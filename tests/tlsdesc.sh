@@ -0,0 +1,34 @@
+#!/bin/bash
+
+# TLS descriptor sequences are rewritten when linked statically
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -fPIC -mtls-dialect=desc -o $test_path/a.o
+extern __thread int other_var;
+static __thread int local_var = 20;
+__thread int zero_var;
+
+int main(void) {
+    if (zero_var != 0)
+        return 1;
+    return other_var + local_var;
+}
+EOF
+
+cat <<EOF | $CC -xc - -c -fPIC -mtls-dialect=desc -o $test_path/b.o
+__thread int other_var = 22;
+EOF
+
+$CC -B. -static -Wl,--relax $test_path/a.o $test_path/b.o -o $test_path/relax
+$CC -B. -static -Wl,--no-relax $test_path/a.o $test_path/b.o -o $test_path/norelax
+qemu-riscv64 $test_path/relax
+test $? = 42 || exit 1
+qemu-riscv64 $test_path/norelax
+test $? = 42 || exit 1
+
+# nothing is left for a resolver to do
+! readelf -r $test_path/norelax | grep -q TLSDESC || exit 1
@@ -0,0 +1,46 @@
+#!/bin/bash
+
+# undefined symbols are reported with their references and a suggestion
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/main.o
+void do_thing(void);
+void missing_a(void);
+void missing_b(void);
+int main(void) {
+    do_thing();
+    missing_a();
+    missing_b();
+    return 0;
+}
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+cat <<EOF | $CC -xc - -c -fno-pic -o $test_path/b.o
+void do_think(void) {}
+EOF
+
+link() {
+    $CC -B. -static -nostdlib -Wl,--no-relax $test_path/main.o $test_path/b.o "$@"
+}
+
+! link -o $test_path/out > $test_path/log 2>&1 || exit 1
+grep -q 'undefined symbol: do_thing' $test_path/log || exit 1
+grep -q '>>> referenced by .*main.o:(.text' $test_path/log || exit 1
+grep -q '>>> did you mean: do_think' $test_path/log || exit 1
+grep -q 'undefined symbol: missing_b' $test_path/log || exit 1
+
+# only the first error is shown
+! link -Wl,--error-limit=1 -o $test_path/out > $test_path/log 2>&1 || exit 1
+grep -q 'too many errors emitted' $test_path/log || exit 1
+! grep -q 'undefined symbol: missing_a' $test_path/log || exit 1
+
+! link -Wl,--no-undefined -o $test_path/out > /dev/null 2>&1 || exit 1
+
+link -Wl,--warn-unresolved-symbols -o $test_path/out > $test_path/log 2>&1 || exit 1
+grep -q 'warning: undefined symbol: do_thing' $test_path/log || exit 1
+link -Wl,--unresolved-symbols=ignore-all -o $test_path/out > /dev/null 2>&1 || exit 1
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+# a version script decides what a shared object exports and under which
+# version, and an executable records the versions it uses
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# foo@VER_1 is kept for old executables, new ones get foo@@VER_2
+cat <<EOF | $CC -xc - -c -fPIC -o $test_path/lib.o
+int get(void) { return 40; }
+int helper(void) { return 1; }
+int foo_v1(void) { return 1; }
+int foo_v2(void) { return 2; }
+asm(".symver foo_v1, foo@VER_1");
+asm(".symver foo_v2, foo@@VER_2");
+EOF
+
+cat <<EOF > $test_path/lib.map
+VER_1 {
+	global: "get";
+	local: *;
+};
+VER_2 {
+	global: foo_v2;
+} VER_1;
+EOF
+
+cat <<EOF | $CC -xc - -c -o $test_path/main.o
+#include <stdio.h>
+int get(void);
+int foo(void);
+int main(void) {
+    printf("%d\n", get() + foo());
+    return 0;
+}
+EOF
+
+$CC -B. -shared $test_path/lib.o -o $test_path/libver.so -Wl,-soname,libver.so \
+    -Wl,--version-script,$test_path/lib.map
+readelf --dyn-syms -W $test_path/libver.so | grep -q ' get@@VER_1$' || exit 1
+readelf --dyn-syms -W $test_path/libver.so | grep -q ' foo@VER_1$' || exit 1
+readelf --dyn-syms -W $test_path/libver.so | grep -q ' foo@@VER_2$' || exit 1
+! readelf --dyn-syms -W $test_path/libver.so | grep -q helper || exit 1
+readelf -V $test_path/libver.so | grep -q 'Name: VER_2' || exit 1
+
+$CC -B. $test_path/main.o -L$test_path -lver -o $test_path/out
+readelf --dyn-syms -W $test_path/out | grep -q ' foo@VER_2' || exit 1
+test "$(LD_LIBRARY_PATH=$test_path qemu-riscv64 -L /usr/riscv64-linux-gnu $test_path/out)" = 42 || exit 1
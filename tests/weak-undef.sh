@@ -0,0 +1,32 @@
+#!/bin/bash
+
+# weak undefined symbols resolve to zero, with absolute and pc relative code
+
+test_name=$(basename $0 .sh)
+test_path=out/tests/$test_name
+
+mkdir -p $test_path
+
+# no libc, _start calls main and exits with its return value
+# the call to hook is never made, but it has to link
+for model in medlow medany; do
+cat <<EOF | $CC -xc - -c -fno-pic -mcmodel=$model -o $test_path/$model.o
+extern void hook(void) __attribute__((weak));
+extern int weak_var __attribute__((weak));
+int main(void) {
+    int ret = 40;
+    if (hook)
+        hook();
+    else
+        ret++;
+    if (&weak_var == 0)
+        ret++;
+    return ret;
+}
+asm(".globl _start\n_start:\n\tcall main\n\tli a7, 93\n\tecall\n");
+EOF
+
+$CC -B. -static -nostdlib -Wl,--no-relax $test_path/$model.o -o $test_path/$model
+qemu-riscv64 $test_path/$model
+test $? = 42 || exit 1
+done